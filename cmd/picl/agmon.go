@@ -7,6 +7,8 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"strings"
 
 	"github.com/rs/zerolog/log"
 	"github.com/urfave/cli/v2"
@@ -15,6 +17,7 @@ import (
 	"github.com/varunamachi/picl/config"
 	"github.com/varunamachi/picl/mon"
 	"github.com/varunamachi/picl/xcutr"
+	"golang.org/x/sync/errgroup"
 )
 
 func getAgentCmd() *cli.Command {
@@ -28,10 +31,133 @@ func getAgentCmd() *cli.Command {
 				Usage: "Port on which the service runs",
 				Value: 20202,
 			},
+			&cli.StringFlag{
+				Name: "name",
+				Usage: "Name of the node used to label the metrics, " +
+					"defaults to the hostname",
+				EnvVars: []string{"PICL_AGENT_NAME"},
+			},
+			&cli.StringFlag{
+				Name: "user-id",
+				Usage: "User allowed to login to the agent, required for " +
+					"controlling services",
+				EnvVars: []string{"PICL_AGENT_USER_ID"},
+			},
+			&cli.StringFlag{
+				Name:    "password",
+				Usage:   "Password for the agent user",
+				Hidden:  true,
+				EnvVars: []string{"PICL_AGENT_PASSWORD"},
+			},
+			&cli.StringFlag{
+				Name: "push-to",
+				Usage: "URL of the monitor to push samples to, for nodes " +
+					"that the monitor cannot reach",
+				EnvVars: []string{"PICL_PUSH_MONITOR_URL"},
+			},
+			&cli.IntFlag{
+				Name:    "push-interval-secs",
+				Usage:   "Interval between samples pushed to the monitor",
+				Value:   5,
+				EnvVars: []string{"PICL_PUSH_INTERVAL_SECS"},
+			},
+			&cli.IntFlag{
+				Name: "push-buffer",
+				Usage: "Number of samples kept while the monitor is " +
+					"unreachable",
+				Value:   3600,
+				EnvVars: []string{"PICL_PUSH_BUFFER"},
+			},
+			&cli.StringFlag{
+				Name: "push-user-id",
+				Usage: "Monitor user to login as while pushing samples, " +
+					"the push user of the node on the monitor",
+				EnvVars: []string{"PICL_PUSH_USER_ID"},
+			},
+			&cli.StringFlag{
+				Name:    "push-password",
+				Usage:   "Password of the monitor user",
+				Hidden:  true,
+				EnvVars: []string{"PICL_PUSH_PASSWORD"},
+			},
+			&cli.IntFlag{
+				Name:    "disk-interval-secs",
+				Usage:   "Interval between disk usage scans",
+				Value:   mon.DefaultSamplingConfig().DiskSecs,
+				EnvVars: []string{"PICL_DISK_INTERVAL_SECS"},
+			},
+			&cli.IntFlag{
+				Name:    "sensor-interval-secs",
+				Usage:   "Interval between temperature and fan sensor reads",
+				Value:   mon.DefaultSamplingConfig().SensorSecs,
+				EnvVars: []string{"PICL_SENSOR_INTERVAL_SECS"},
+			},
+			&cli.IntFlag{
+				Name:    "throttling-interval-secs",
+				Usage:   "Interval between throttling state checks",
+				Value:   mon.DefaultSamplingConfig().ThrottlingSecs,
+				EnvVars: []string{"PICL_THROTTLING_INTERVAL_SECS"},
+			},
+			&cli.IntFlag{
+				Name:    "procs-interval-secs",
+				Usage:   "Interval between process list scans",
+				Value:   mon.DefaultSamplingConfig().ProcsSecs,
+				EnvVars: []string{"PICL_PROCS_INTERVAL_SECS"},
+			},
+			&cli.IntFlag{
+				Name: "metrics-interval-secs",
+				Usage: "Age up to which scrapes of the metrics endpoint " +
+					"get the last sample instead of taking one",
+				Value:   mon.DefaultSamplingConfig().MetricsSecs,
+				EnvVars: []string{"PICL_METRICS_INTERVAL_SECS"},
+			},
 		},
 		Action: func(ctx *cli.Context) error {
 			port := ctx.Int("port")
-			return mon.RunAgent(fmt.Sprintf(":%d", port))
+			mon.ConfigureSampling(&mon.SamplingConfig{
+				DiskSecs:       ctx.Int("disk-interval-secs"),
+				SensorSecs:     ctx.Int("sensor-interval-secs"),
+				ThrottlingSecs: ctx.Int("throttling-interval-secs"),
+				ProcsSecs:      ctx.Int("procs-interval-secs"),
+				MetricsSecs:    ctx.Int("metrics-interval-secs"),
+			})
+			name := ctx.String("name")
+			if name == "" {
+				name, _ = os.Hostname()
+			}
+			var users []*mon.User
+			if userId := ctx.String("user-id"); userId != "" {
+				users = append(users, &mon.User{
+					UserId:   userId,
+					Password: ctx.String("password"),
+				})
+			}
+
+			monitorURL := ctx.String("push-to")
+			if monitorURL == "" {
+				return mon.RunAgent(name, uint32(port), users)
+			}
+
+			pushCfg := &mon.PushConfig{
+				MonitorURL:   monitorURL,
+				Node:         name,
+				IntervalSecs: ctx.Int("push-interval-secs"),
+				MaxBuffered:  ctx.Int("push-buffer"),
+			}
+			if userId := ctx.String("push-user-id"); userId != "" {
+				pushCfg.AuthData = httpx.AuthData{
+					"userId":   userId,
+					"password": ctx.String("push-password"),
+				}
+			}
+			eg, gtx := errgroup.WithContext(ctx.Context)
+			eg.Go(func() error {
+				return mon.RunPusher(gtx, pushCfg)
+			})
+			eg.Go(func() error {
+				return mon.RunAgent(name, uint32(port), users)
+			})
+			return eg.Wait()
 		},
 	}
 }
@@ -53,14 +179,22 @@ func getMonitorCmd() *cli.Command {
 				Value: 8000,
 			},
 			&cli.StringFlag{
-				Name:  "handler",
-				Usage: "Handler type, one of: tui | simple | noop",
+				Name: "handler",
+				Usage: "Comma separated list of handlers, each one of: " +
+					"tui | simple | noop | jsonl:<path> | csv:<path>",
 				Value: "tui",
 			},
+			&cli.StringFlag{
+				Name: "gpio-driver",
+				Usage: "GPIO backend for relays, one of: rpio | cdev | sim. " +
+					"Overrides the driver given in config",
+				EnvVars: []string{"PICL_GPIO_DRIVER"},
+			},
 		},
 		Action: func(ctx *cli.Context) error {
 			port := ctx.Uint("port")
 			handler := ctx.String("handler")
+			gpioDriver := ctx.String("gpio-driver")
 
 			provider, err := config.NewFromCli(ctx)
 			if err != nil {
@@ -68,25 +202,25 @@ func getMonitorCmd() *cli.Command {
 			}
 
 			monConfig := provider.MonitorConfig()
+			relayConfig := provider.RelayConfig()
+			if gpioDriver != "" {
+				relayConfig.Driver = mon.GpioDriverType(gpioDriver)
+			}
 
 			hdl, gtx, err := newHandler(handler, monConfig)
 			if err != nil {
 				return errx.Wrap(err)
 			}
 			var printer io.Writer
-			if handler != "tui" {
+			if !slices.Contains(handlerKinds(handler), "tui") {
 				printer = os.Stdout
 			}
 			defer hdl.Close()
 
-			rcfg := &mon.RelayConfig{
-				GpioPins:       []uint8{22, 23, 24, 25},
-				IsNormallyOpen: true,
-			}
 			monitor, err := mon.NewMonitor(
 				gtx,
 				monConfig,
-				rcfg,
+				relayConfig,
 				hdl,
 				httpx.NewServer(printer, nil))
 
@@ -104,17 +238,79 @@ func getMonitorCmd() *cli.Command {
 	}
 }
 
-func newHandler(hdl string, cfg *mon.Config) (
+// newHandler - creates the handlers from a comma separated list like
+// tui,jsonl:/var/log/picl.jsonl. Many handlers are combined so that a failing
+// one does not affect the others, the monitor stops when any of them is done
+func newHandler(handlers string, cfg *mon.Config) (
+	mon.Handler, context.Context, error) {
+	specs := make([]string, 0, 4)
+	for _, spec := range strings.Split(handlers, ",") {
+		if spec = strings.TrimSpace(spec); spec != "" {
+			specs = append(specs, spec)
+		}
+	}
+	kinds := handlerKinds(handlers)
+	if len(specs) == 0 {
+		return nil, nil, fmt.Errorf("no handler selected")
+	}
+	if slices.Contains(kinds, "tui") && slices.Contains(kinds, "simple") {
+		return nil, nil, fmt.Errorf(
+			"simple handler prints to the terminal, it cannot be used " +
+				"along with tui")
+	}
+	if len(specs) == 1 {
+		return newSingleHandler(specs[0], cfg)
+	}
+
+	multi := mon.NewMultiHandler()
+	done := make(chan struct{}, len(specs))
+	for _, spec := range specs {
+		hdl, htx, err := newSingleHandler(spec, cfg)
+		if err != nil {
+			multi.Close()
+			return nil, nil, err
+		}
+		multi.Add(spec, hdl)
+		go func() {
+			<-htx.Done()
+			done <- struct{}{}
+		}()
+	}
+
+	gtx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-done
+		cancel()
+	}()
+	return multi, gtx, nil
+}
+
+func newSingleHandler(spec string, cfg *mon.Config) (
 	mon.Handler, context.Context, error) {
-	switch hdl {
+	kind, path, _ := strings.Cut(spec, ":")
+	switch kind {
 	case "simple":
 		return mon.NewSimpleHandler(cfg)
 	case "noop":
 		return mon.NewNoOpHandler(cfg)
 	case "tui":
 		return mon.NewTuiHandler(cfg)
+	case "jsonl":
+		return mon.NewJSONLHandler(cfg, path)
+	case "csv":
+		return mon.NewCSVHandler(cfg, path)
+	}
+	return nil, nil, fmt.Errorf("invalid handler '%s' selected", spec)
+}
+
+// handlerKinds - kinds of the handlers in the list, without their arguments
+func handlerKinds(handlers string) []string {
+	kinds := make([]string, 0, 4)
+	for _, spec := range strings.Split(handlers, ",") {
+		kind, _, _ := strings.Cut(strings.TrimSpace(spec), ":")
+		kinds = append(kinds, kind)
 	}
-	return nil, nil, fmt.Errorf("invalid handler '%s' selected", hdl)
+	return kinds
 }
 
 func getBuildInstallCmd() *cli.Command {
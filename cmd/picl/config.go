@@ -191,3 +191,91 @@ func getDecryptCmd() *cli.Command {
 		},
 	}
 }
+
+func getConfigCmd() *cli.Command {
+	return &cli.Command{
+		Name:        "config",
+		Description: "Manage picl configuration files",
+		Usage:       "Manage picl configuration files",
+		Subcommands: []*cli.Command{
+			getConfigMigrateCmd(),
+			getConfigShowCmd(),
+		},
+	}
+}
+
+func getConfigMigrateCmd() *cli.Command {
+	return &cli.Command{
+		Name: "migrate",
+		Description: "Upgrade configuration file to the current schema " +
+			"version in place, original file is backed up",
+		Usage: "Upgrade configuration file to the current schema version",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "config",
+				Usage:   "Name of the picl config",
+				Value:   "default",
+				EnvVars: []string{"PICL_CONFIG"},
+			},
+			&cli.BoolFlag{
+				Name:  "all",
+				Usage: "Migrate all the configs found in ~/.picl",
+				Value: false,
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			names := []string{ctx.String("config")}
+			if ctx.Bool("all") {
+				var err error
+				names, err = config.ListConfigs()
+				if err != nil {
+					return errx.Wrap(err)
+				}
+			}
+
+			for _, name := range names {
+				if err := config.MigrateFile(name); err != nil {
+					log.Error().Err(err).Str("config", name).
+						Msg("failed to migrate config")
+					return errx.Wrap(err)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func getConfigShowCmd() *cli.Command {
+	return &cli.Command{
+		Name:        "show",
+		Description: "Print the configuration identified by config name",
+		Usage:       "Print the configuration identified by config name",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "config",
+				Usage:   "Name of the picl config",
+				Value:   "default",
+				EnvVars: []string{"PICL_CONFIG"},
+			},
+			&cli.BoolFlag{
+				Name: "resolved",
+				Usage: "Print effective per-host settings, with defaults " +
+					"applied, environment variables and host ranges expanded",
+				Value: false,
+			},
+			&cli.BoolFlag{
+				Name:  "show-secrets",
+				Usage: "Print passwords instead of masking them",
+				Value: false,
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			err := config.Show(
+				ctx.String("config"),
+				ctx.Bool("resolved"),
+				ctx.Bool("show-secrets"),
+				os.Stdout)
+			return errx.Wrap(err)
+		},
+	}
+}
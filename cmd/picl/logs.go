@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+	"github.com/varunamachi/picl/mon"
+	"github.com/varunamachi/picl/xcutr"
+)
+
+func getLogsCmd() *cli.Command {
+	return &cli.Command{
+		Name: "logs",
+		Usage: "Show journal or log files of the nodes, prefixed with " +
+			"node names",
+		Description: "Streams logs from the agents running on the nodes",
+		Flags: withAgentFlags(
+			&cli.BoolFlag{
+				Name:    "follow",
+				Aliases: []string{"f"},
+				Usage:   "Keep streaming new log lines",
+			},
+			&cli.StringFlag{
+				Name:  "unit",
+				Usage: "Only show journal entries of this systemd unit",
+			},
+			&cli.StringFlag{
+				Name: "priority",
+				Usage: "Only show journal entries of this priority or " +
+					"higher, 0-7 or emerg | alert | crit | err | warning " +
+					"| notice | info | debug",
+			},
+			&cli.StringFlag{
+				Name:  "file",
+				Usage: "Tail a log file instead of journal: picl | syslog",
+			},
+			&cli.IntFlag{
+				Name:    "lines",
+				Aliases: []string{"n"},
+				Usage:   "Number of existing lines to show",
+				Value:   10,
+			},
+		),
+		Action: func(ctx *cli.Context) error {
+			agents, err := selectAgents(ctx)
+			if err != nil {
+				return err
+			}
+
+			query := &mon.LogQuery{
+				Unit:     ctx.String("unit"),
+				Priority: ctx.String("priority"),
+				File:     ctx.String("file"),
+				Lines:    ctx.Int("lines"),
+				Follow:   ctx.Bool("follow"),
+			}
+
+			wg := sync.WaitGroup{}
+			for _, agent := range agents {
+				wg.Add(1)
+				go func(agent *mon.AgentConfig) {
+					defer wg.Done()
+					color := ""
+					if agent.SshOpts != nil {
+						color = agent.SshOpts.Color
+					}
+					writer := xcutr.NewNamedColorNodeWriter(
+						agent.Name, os.Stdout, color)
+					err := mon.StreamLogs(ctx.Context, agent, query, writer)
+					if err != nil {
+						log.Error().Err(err).
+							Str("node", agent.Name).
+							Msg("failed to stream logs")
+					}
+				}(agent)
+			}
+			wg.Wait()
+			return nil
+		},
+	}
+}
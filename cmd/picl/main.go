@@ -33,6 +33,10 @@ func main() {
 			getCopyIdCmd(),
 			getEncryptCmd(),
 			getDecryptCmd(),
+			getConfigCmd(),
+			getPowerCmd(),
+			getServicesCmd(),
+			getLogsCmd(),
 		},
 		Usage: "If no valid subcommand is given - it acts as 'exec' " +
 			"subcommand. I.e It treats the argument as a " +
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"github.com/varunamachi/libx/errx"
+	"github.com/varunamachi/picl/config"
+	"github.com/varunamachi/picl/mon"
+	"github.com/varunamachi/picl/xcutr"
+)
+
+var ErrNodeNotGiven = errors.New("picl.power.nodeNotGiven")
+
+func getPowerCmd() *cli.Command {
+	return &cli.Command{
+		Name: "power",
+		Description: "Control power of the nodes through the relays " +
+			"managed by the monitor",
+		Usage: "Control power of the nodes",
+		Subcommands: []*cli.Command{
+			getPowerActionCmd(mon.PowerOn, "Turn the node on"),
+			getPowerActionCmd(mon.PowerOff, "Turn the node off"),
+			getPowerActionCmd(mon.PowerCycle, "Power cycle the node"),
+			getPowerStatusCmd(),
+		},
+	}
+}
+
+func getPowerActionCmd(action mon.PowerAction, desc string) *cli.Command {
+	flags := []cli.Flag{
+		&cli.StringFlag{
+			Name:    "config",
+			Usage:   "Name of the picl config, used for graceful shutdown",
+			Value:   "default",
+			EnvVars: []string{"PICL_CONFIG"},
+		},
+	}
+	if action != mon.PowerOn {
+		flags = append(flags,
+			&cli.BoolFlag{
+				Name: "graceful",
+				Usage: "Shutdown the node over SSH and wait for it to go " +
+					"down before cutting the power",
+				Value: false,
+			},
+			&cli.DurationFlag{
+				Name:  "shutdown-timeout",
+				Usage: "Maximum time to wait for the node to shutdown",
+				Value: 2 * time.Minute,
+			},
+		)
+	}
+	if action == mon.PowerCycle {
+		flags = append(flags, &cli.DurationFlag{
+			Name:  "delay",
+			Usage: "Time to wait before turning the power back on",
+			Value: mon.DefaultCycleDelay,
+		})
+	}
+
+	return &cli.Command{
+		Name:        string(action),
+		Description: desc,
+		Usage:       desc,
+		ArgsUsage:   "<node>",
+		Flags:       mon.WithClientFlags(true, flags...),
+		Action: func(ctx *cli.Context) error {
+			node := ctx.Args().First()
+			if node == "" {
+				return errx.Errf(ErrNodeNotGiven, "node name is required")
+			}
+
+			client, err := mon.CreateClient(ctx)
+			if err != nil {
+				return errx.Wrap(err)
+			}
+
+			if ctx.Bool("graceful") {
+				if err := shutdownNode(ctx, node); err != nil {
+					return errx.Wrap(err)
+				}
+			}
+
+			status, err := mon.SetPower(
+				ctx.Context, client, node, action, ctx.Duration("delay"))
+			if err != nil {
+				return errx.Wrap(err)
+			}
+			printPowerStatus(status)
+			if action == mon.PowerCycle {
+				fmt.Printf("power goes back on in %s\n", ctx.Duration("delay"))
+			}
+			return nil
+		},
+	}
+}
+
+func getPowerStatusCmd() *cli.Command {
+	return &cli.Command{
+		Name:        "status",
+		Description: "Show power state of all the relays and their nodes",
+		Usage:       "Show power state of all the relays and their nodes",
+		Flags:       mon.WithClientFlags(true),
+		Action: func(ctx *cli.Context) error {
+			client, err := mon.CreateClient(ctx)
+			if err != nil {
+				return errx.Wrap(err)
+			}
+
+			statuses, err := mon.GetPowerStatuses(ctx.Context, client)
+			if err != nil {
+				return errx.Wrap(err)
+			}
+			fmt.Printf("%4s  %-15s %-15s %s\n", "Slot", "Relay", "Node", "State")
+			for _, status := range statuses {
+				printPowerStatus(status)
+			}
+			return nil
+		},
+	}
+}
+
+func printPowerStatus(status *mon.PowerStatus) {
+	state := "off"
+	if status.On {
+		state = "on"
+	}
+	fmt.Printf("%4d  %-15s %-15s %s\n",
+		status.Slot, status.Relay, status.Host, state)
+}
+
+func shutdownNode(ctx *cli.Context, node string) error {
+	provider, err := config.NewFromCli(ctx)
+	if err != nil {
+		return errx.Wrap(err)
+	}
+
+	for _, opts := range provider.ExecuterConfig().Opts {
+		if opts.Name == node {
+			return mon.ShutdownNode(opts, ctx.Duration("shutdown-timeout"))
+		}
+	}
+	return errx.Errf(xcutr.ErrInvalidNode,
+		"could not find node '%s' in config", node)
+}
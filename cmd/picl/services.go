@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"github.com/varunamachi/libx/errx"
+	"github.com/varunamachi/picl/config"
+	"github.com/varunamachi/picl/mon"
+)
+
+var ErrUnitNotGiven = errors.New("picl.services.unitNotGiven")
+
+func getServicesCmd() *cli.Command {
+	return &cli.Command{
+		Name:        "services",
+		Description: "Inspect systemd services on the nodes through agents",
+		Usage:       "Inspect systemd services on the nodes",
+		Subcommands: []*cli.Command{
+			getServiceStatusCmd(),
+		},
+	}
+}
+
+func getServiceStatusCmd() *cli.Command {
+	return &cli.Command{
+		Name:        "status",
+		Usage:       "Show state of a systemd unit on all the nodes",
+		Description: "Show state of a systemd unit on all the nodes",
+		ArgsUsage:   "<unit>",
+		Flags: withAgentFlags(
+			&cli.DurationFlag{
+				Name:  "timeout",
+				Usage: "Time to wait for each agent to respond",
+				Value: 10 * time.Second,
+			},
+		),
+		Action: func(ctx *cli.Context) error {
+			unit := ctx.Args().First()
+			if unit == "" {
+				return errx.Errf(ErrUnitNotGiven, "unit name is not given")
+			}
+
+			agents, err := selectAgents(ctx)
+			if err != nil {
+				return err
+			}
+
+			statuses := make([]*mon.UnitStatus, len(agents))
+			errs := make([]error, len(agents))
+			wg := sync.WaitGroup{}
+			for idx, agent := range agents {
+				wg.Add(1)
+				go func(idx int, agent *mon.AgentConfig) {
+					defer wg.Done()
+					client, err := mon.NewAgentClient(
+						ctx.Context, agent, ctx.Duration("timeout"))
+					if err != nil {
+						errs[idx] = err
+						return
+					}
+					statuses[idx], errs[idx] = mon.GetUnitStatus(
+						ctx.Context, client, unit)
+				}(idx, agent)
+			}
+			wg.Wait()
+
+			fmt.Printf("%-15s %-30s %-10s %-10s %s\n",
+				"NODE", "UNIT", "LOAD", "ACTIVE", "SUB")
+			for idx, agent := range agents {
+				if errs[idx] != nil {
+					fmt.Printf("%-15s %-30s %s\n",
+						agent.Name, unit, "error: "+errs[idx].Error())
+					continue
+				}
+				st := statuses[idx]
+				fmt.Printf("%-15s %-30s %-10s %-10s %s\n",
+					agent.Name, st.Unit, st.Load, st.Active, st.Sub)
+			}
+			return nil
+		},
+	}
+}
+
+// withAgentFlags - flags for commands that talk to the agents of the nodes
+// in a config
+func withAgentFlags(flags ...cli.Flag) []cli.Flag {
+	common := []cli.Flag{
+		&cli.StringFlag{
+			Name:    "config",
+			Usage:   "Name of the picl config",
+			Value:   "default",
+			EnvVars: []string{"PICL_CONFIG"},
+		},
+		&cli.StringFlag{
+			Name:  "only",
+			Usage: "Comma seperated list of nodes to include",
+		},
+		&cli.StringFlag{
+			Name:  "except",
+			Usage: "Comma seperated list of nodes to exclude",
+		},
+	}
+	return append(common, flags...)
+}
+
+// selectAgents - gives the agents of the nodes from the config, filtered by
+// the only and except flags
+func selectAgents(ctx *cli.Context) ([]*mon.AgentConfig, error) {
+	only := splitNodes(ctx.String("only"))
+	except := splitNodes(ctx.String("except"))
+
+	provider, err := config.NewFromCli(ctx)
+	if err != nil {
+		return nil, errx.Wrap(err)
+	}
+
+	agents := make([]*mon.AgentConfig, 0, 10)
+	for _, agent := range provider.MonitorConfig().AgentConfig {
+		if len(only) != 0 && !slices.Contains(only, agent.Name) {
+			continue
+		}
+		if slices.Contains(except, agent.Name) {
+			continue
+		}
+		agents = append(agents, agent)
+	}
+	return agents, nil
+}
+
+func splitNodes(nodes string) []string {
+	if nodes == "" {
+		return nil
+	}
+	names := strings.Split(nodes, ",")
+	for idx := range names {
+		names[idx] = strings.TrimSpace(names[idx])
+	}
+	return names
+}
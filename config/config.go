@@ -2,6 +2,7 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"os/user"
@@ -18,30 +19,75 @@ import (
 	"github.com/varunamachi/picl/xcutr"
 )
 
+var (
+	ErrInvalidRelay     = errors.New("config.relay.invalid")
+	ErrInvalidAgentMode = errors.New("config.agent.invalidMode")
+)
+
 type Provider interface {
 	ExecuterConfig() *xcutr.Config
 	MonitorConfig() *mon.Config
+	RelayConfig() *mon.RelayConfig
 }
 
 type monitor struct {
-	Height int    `json:"height"`
-	Width  int    `json:"width"`
-	GoArch string `json:"goArch"`
+	Height     int                `json:"height"`
+	Width      int                `json:"width"`
+	GoArch     string             `json:"goArch"`
+	GpioDriver mon.GpioDriverType `json:"gpioDriver,omitempty"`
+	GpioChip   string             `json:"gpioChip,omitempty"`
+
+	// RelayStateFile - defaults to ~/.picl/<name>.relays.json
+	RelayStateFile string `json:"relayStateFile,omitempty"`
+
+	// EventLog - defaults to ~/.picl/<name>.events.jsonl
+	EventLog string              `json:"eventLog,omitempty"`
+	Watchdog *mon.WatchdogConfig `json:"watchdog,omitempty"`
+
+	Schedules []*mon.Schedule `json:"schedules,omitempty"`
+
+	// Users - APIs that change relay states require a login. Without users
+	// they are disabled, unless Insecure is set to leave them open
+	Users    []*mon.User `json:"users,omitempty"`
+	Insecure bool        `json:"insecure,omitempty"`
+
+	// History - defaults to ~/.picl/history/<name> with default tiers
+	History *mon.HistoryConfig `json:"history,omitempty"`
+
+	Alerts *mon.AlertConfig `json:"alerts,omitempty"`
+
+	// PushTimeoutSecs - push mode agents that have not pushed for this long
+	// are treated as unreachable, defaults to 30 seconds
+	PushTimeoutSecs int `json:"pushTimeoutSecs,omitempty"`
+
+	// PollIntervalMs, PollTimeoutMs - for all the agents unless overridden
+	// for a host, default to 1 and 2 seconds
+	PollIntervalMs int `json:"pollIntervalMs,omitempty"`
+	PollTimeoutMs  int `json:"pollTimeoutMs,omitempty"`
 }
 
 type executer struct {
-	SshPort   int                 `json:"sshPort"`
-	UserName  string              `json:"userName"`
-	Password  string              `json:"password"`
-	AuthMehod xcutr.SshAuthMethod `json:"authMethod"`
+	SshPort    int                 `json:"sshPort,omitempty"`
+	UserName   string              `json:"userName,omitempty"`
+	Password   string              `json:"password,omitempty"`
+	AuthMethod xcutr.SshAuthMethod `json:"authMethod,omitempty"`
 	// AuthData  map[string]string   `json:"authData"`
-	Color string `json:"color"`
+	Color string `json:"color,omitempty"`
 }
 
+// agent - mode is one of pull | push, pull being the default. Push mode agents
+// post their samples to the monitor instead of being polled by it, logged in
+// as the push user, which is the node name unless given. Poll interval and
+// timeout override the ones of the monitor
 type agent struct {
-	Port     int             `json:"port"`
-	Protocol string          `json:"protocol"`
-	AuthData *httpx.AuthData `json:"authData"`
+	Port     int             `json:"port,omitempty"`
+	Protocol string          `json:"protocol,omitempty"`
+	AuthData *httpx.AuthData `json:"authData,omitempty"`
+	Mode     mon.AgentMode   `json:"mode,omitempty"`
+	PushUser string          `json:"pushUser,omitempty"`
+
+	PollIntervalMs int `json:"pollIntervalMs,omitempty"`
+	PollTimeoutMs  int `json:"pollTimeoutMs,omitempty"`
 }
 
 type host struct {
@@ -51,16 +97,32 @@ type host struct {
 	Agent    agent    `json:"agent"`
 }
 
+// relay - a relay wired to a GPIO pin of the machine running the monitor.
+// Relays are normally open unless specified otherwise. Startup mode is one of
+// last | on | off, last being the default. The mode applies only to relays
+// with a persisted state, others keep their current level on startup
+type relay struct {
+	Name           string          `json:"name"`
+	Pin            uint8           `json:"pin"`
+	IsNormallyOpen *bool           `json:"isNormallyOpen,omitempty"`
+	Host           string          `json:"host,omitempty"`
+	StartupMode    mon.StartupMode `json:"startupMode,omitempty"`
+}
+
 type PiclConfig struct {
-	Name    string  `json:"name"`
-	Monitor monitor `json:"monitor"`
-	Hosts   []*host `json:"hosts"`
+	Version  int      `json:"version"`
+	Name     string   `json:"name"`
+	Monitor  monitor  `json:"monitor"`
+	Defaults defaults `json:"defaults"`
+	Hosts    []*host  `json:"hosts"`
+	Relays   []*relay `json:"relays,omitempty"`
 }
 
 type configProvider struct {
 	// path string
 	eCfg *xcutr.Config
 	mCfg *mon.Config
+	rCfg *mon.RelayConfig
 }
 
 func (cp *configProvider) ExecuterConfig() *xcutr.Config {
@@ -71,113 +133,244 @@ func (cp *configProvider) MonitorConfig() *mon.Config {
 	return cp.mCfg
 }
 
+func (cp *configProvider) RelayConfig() *mon.RelayConfig {
+	return cp.rCfg
+}
+
 func NewFromCli(ctx *cli.Context) (Provider, error) {
 	cfg := ctx.String("config")
 	if cfg == "" {
 		cfg = "default"
 	}
-	cfgPath := filepath.Join(
-		iox.MustGetUserHome(), ".picl", cfg+".config.json")
-	if iox.ExistsAsFile(cfgPath) {
-		data, err := os.ReadFile(cfgPath)
-		if err != nil {
-			err := fmt.Errorf("failed to read configuration %s: %w", cfg, err)
-			log.Error().Err(err).Msg("")
-			return nil, err
-		}
-		return New(data)
-	}
-
-	cfgPath = filepath.Join(
-		iox.MustGetUserHome(), ".picl", cfg+".config.json.enc")
-	if iox.ExistsAsFile(cfgPath) {
-		pw := iox.AskPassword("Please Enter Config Password")
 
-		data, err := os.ReadFile(cfgPath)
-		if err != nil {
-			e := fmt.Errorf("failed to read configuration %s", cfg)
-			log.Error().Err(err).Msg(e.Error())
-			return nil, e
-		}
-
-		data, err = iox.NewCryptor(pw).Decrypt(data)
-		if err != nil {
-			e := fmt.Errorf(
-				"failed to decrypt configuration %s", cfg)
-			log.Error().Err(err).Msg(e.Error())
-			return nil, e
-		}
-
-		return New(data)
+	cf, err := loadConfigFile(cfg)
+	if err != nil {
+		log.Error().Err(err).Str("config", cfg).Msg("failed to load config")
+		return nil, err
 	}
+	return New(cf.data)
+}
 
-	err := fmt.Errorf("could not find configuration %s", cfg)
-	log.Error().Err(err).Msg("")
-	return nil, err
+// loadConfigFile - reads the named config and upgrades it to the current
+// schema version in memory if required. The file is left as it is, it is
+// only rewritten by the 'config migrate' command
+func loadConfigFile(cfgName string) (*configFile, error) {
+	cf, err := readConfigFile(cfgName)
+	if err != nil {
+		return nil, err
+	}
+	migrated, changed, err := Migrate(cf.data)
+	if err != nil {
+		return nil, errx.Errf(err, "failed to migrate config %s", cfgName)
+	}
+	if changed {
+		log.Warn().Str("config", cfgName).
+			Msg("config is of an older version, it is upgraded only in " +
+				"memory, run 'picl config migrate' to update the file")
+		cf.data = migrated
+	}
+	return cf, nil
 }
 
 func New(data []byte) (Provider, error) {
+	data, err := expandEnv(data)
+	if err != nil {
+		return nil, err
+	}
+
 	cfg := PiclConfig{}
 	if err := json.Unmarshal(data, &cfg); err != nil {
 		return nil, err
 	}
+
+	cfg.Hosts, err = expandHosts(cfg.Hosts)
+	if err != nil {
+		return nil, err
+	}
 	return new(&cfg)
 }
 
 func new(cfg *PiclConfig) (Provider, error) {
+	global, err := loadGlobalDefaults()
+	if err != nil {
+		return nil, err
+	}
+	hosts := cfg.resolve(global)
+
 	cp := configProvider{}
 	cp.eCfg = &xcutr.Config{
 		Name: cfg.Name,
-		Opts: make([]*xcutr.SshConnOpts, len(cfg.Hosts)),
+		Opts: make([]*xcutr.SshConnOpts, len(hosts)),
 	}
 	cp.mCfg = &mon.Config{
-		Name:        cfg.Name,
-		Height:      cfg.Monitor.Height,
-		Width:       cfg.Monitor.Width,
-		GoArch:      cfg.Monitor.GoArch,
-		AgentConfig: make([]*mon.AgentConfig, len(cfg.Hosts)),
-	}
+		Name:         cfg.Name,
+		Height:       cfg.Monitor.Height,
+		Width:        cfg.Monitor.Width,
+		GoArch:       cfg.Monitor.GoArch,
+		EventLogPath: cfg.Monitor.EventLog,
+		Watchdog:     cfg.Monitor.Watchdog,
+		Schedules:    cfg.Monitor.Schedules,
+		Users:        cfg.Monitor.Users,
+		Insecure:     cfg.Monitor.Insecure,
+		Alerts:       cfg.Monitor.Alerts,
+		AgentConfig:  make([]*mon.AgentConfig, len(hosts)),
+	}
+	cp.mCfg.PushTimeoutSecs = cfg.Monitor.PushTimeoutSecs
+	cp.mCfg.PollIntervalMs = cfg.Monitor.PollIntervalMs
+	cp.mCfg.PollTimeoutMs = cfg.Monitor.PollTimeoutMs
+	cp.mCfg.History = historyConfig(cfg)
+	if cp.mCfg.EventLogPath == "" {
+		cp.mCfg.EventLogPath = filepath.Join(
+			iox.MustGetUserHome(), ".picl", stateName(cfg)+".events.jsonl")
+	}
+
+	for i, h := range hosts {
+		switch h.Agent.Mode {
+		case "", mon.AgentPull, mon.AgentPush:
+		default:
+			return nil, errx.Errf(ErrInvalidAgentMode,
+				"host '%s' has invalid agent mode '%s', should be one of: "+
+					"pull | push", h.Name, h.Agent.Mode)
+		}
 
-	for i, h := range cfg.Hosts {
 		cp.eCfg.Opts[i] = &xcutr.SshConnOpts{
-			Name:      h.Name,
-			Host:      h.Host,
-			Port:      h.Executer.SshPort,
-			UserName:  h.Executer.UserName,
-			AuthMehod: h.Executer.AuthMehod,
-			Password:  h.Executer.Password,
-			Color:     h.Executer.Color,
+			Name:       h.Name,
+			Host:       h.Host,
+			Port:       h.Executer.SshPort,
+			UserName:   h.Executer.UserName,
+			AuthMethod: h.Executer.AuthMethod,
+			Password:   h.Executer.Password,
+			Color:      h.Executer.Color,
 		}
 
-		protocol := h.Agent.Protocol
-		if protocol == "" {
-			protocol = "http"
-		}
-		port := h.Agent.Port
-		if port == 0 {
-			port = 20202
-		}
-		address := fmt.Sprintf("%s://%s:%d", protocol, h.Host, port)
+		address := fmt.Sprintf(
+			"%s://%s:%d", h.Agent.Protocol, h.Host, h.Agent.Port)
 		cp.mCfg.AgentConfig[i] = &mon.AgentConfig{
 			Name:     h.Name,
 			Address:  address,
 			AuthData: h.Agent.AuthData,
+			SshOpts:  cp.eCfg.Opts[i],
+			Mode:     h.Agent.Mode,
+			PushUser: h.Agent.PushUser,
+
+			PollIntervalMs: h.Agent.PollIntervalMs,
+			PollTimeoutMs:  h.Agent.PollTimeoutMs,
 		}
 	}
 
+	cp.rCfg, err = relayConfig(cfg.Relays, hosts)
+	if err != nil {
+		return nil, err
+	}
+	cp.rCfg.Driver = cfg.Monitor.GpioDriver
+	cp.rCfg.Chip = cfg.Monitor.GpioChip
+	cp.rCfg.StateFile = cfg.Monitor.RelayStateFile
+	if cp.rCfg.StateFile == "" {
+		cp.rCfg.StateFile = filepath.Join(
+			iox.MustGetUserHome(), ".picl", stateName(cfg)+".relays.json")
+	}
+
 	return &cp, nil
 }
 
+func historyConfig(cfg *PiclConfig) *mon.HistoryConfig {
+	hc := cfg.Monitor.History
+	if hc == nil {
+		hc = &mon.HistoryConfig{}
+	}
+	if hc.Dir == "" {
+		hc.Dir = filepath.Join(
+			iox.MustGetUserHome(), ".picl", "history", stateName(cfg))
+	}
+	return hc
+}
+
+// stateName - name used for files holding runtime state of the cluster
+func stateName(cfg *PiclConfig) string {
+	if cfg.Name == "" {
+		return "default"
+	}
+	return cfg.Name
+}
+
+func relayConfig(relays []*relay, hosts []*host) (*mon.RelayConfig, error) {
+	if len(relays) == 0 {
+		return mon.DefaultRelayConfig(), nil
+	}
+
+	known := make(map[string]bool, len(hosts))
+	for _, h := range hosts {
+		known[h.Name] = true
+	}
+
+	usedPins := map[uint8]string{}
+	rcfg := &mon.RelayConfig{
+		Relays: make([]*mon.Relay, 0, len(relays)),
+	}
+	for idx, r := range relays {
+		name := r.Name
+		if name == "" {
+			name = fmt.Sprintf("relay-%d", idx)
+		}
+		if other, found := usedPins[r.Pin]; found {
+			return nil, errx.Errf(ErrInvalidRelay,
+				"relays '%s' and '%s' use same GPIO pin %d",
+				other, name, r.Pin)
+		}
+		usedPins[r.Pin] = name
+
+		if r.Host != "" && !known[r.Host] {
+			return nil, errx.Errf(ErrInvalidRelay,
+				"relay '%s' refers to unknown host '%s'", name, r.Host)
+		}
+
+		switch r.StartupMode {
+		case "", mon.StartupLast, mon.StartupOn, mon.StartupOff:
+		default:
+			return nil, errx.Errf(ErrInvalidRelay,
+				"relay '%s' has invalid startup mode '%s', should be one "+
+					"of: last | on | off", name, r.StartupMode)
+		}
+
+		isNO := true
+		if r.IsNormallyOpen != nil {
+			isNO = *r.IsNormallyOpen
+		}
+		rcfg.Relays = append(rcfg.Relays, &mon.Relay{
+			Name:           name,
+			Pin:            r.Pin,
+			IsNormallyOpen: isNO,
+			Host:           r.Host,
+			StartupMode:    r.StartupMode,
+		})
+	}
+	return rcfg, nil
+}
+
 func CreateConfigTemplate(configName string, numHosts int) error {
 
 	config := PiclConfig{
-		Name: "",
+		Version: CurrentVersion,
+		Name:    "",
 		Monitor: monitor{
 			Height: 20,
-			Width:  60,
+			Width:  120,
 			GoArch: "AARCH64",
 		},
-		Hosts: make([]*host, numHosts),
+		Defaults: defaults{
+			Executer: executer{
+				SshPort:    22,
+				UserName:   "",
+				AuthMethod: "PublicKey",
+				Color:      "",
+			},
+			Agent: agent{
+				Port:     defaultAgentPort,
+				Protocol: defaultAgentProtocol,
+				AuthData: nil,
+			},
+		},
+		Hosts: make([]*host, 0, numHosts),
 	}
 
 	if numHosts == 0 {
@@ -188,18 +381,6 @@ func CreateConfigTemplate(configName string, numHosts int) error {
 		config.Hosts = append(config.Hosts, &host{
 			Name: fmt.Sprintf("host_%d", i),
 			Host: fmt.Sprintf("host%d", i),
-			Executer: executer{
-				SshPort:   20,
-				UserName:  "",
-				AuthMehod: "PublicKey",
-				// AuthData:  ,
-				Color: "",
-			},
-			Agent: agent{
-				Port:     8000,
-				Protocol: "http",
-				AuthData: nil,
-			},
 		})
 	}
 	return generateConfig(&config, configName, false, "")
@@ -217,8 +398,9 @@ func CreateConfig(name string) error {
 	numHosts := gtr.Int("Number of Hosts")
 
 	conf := PiclConfig{
-		Name:  name,
-		Hosts: make([]*host, numHosts),
+		Version: CurrentVersion,
+		Name:    name,
+		Hosts:   make([]*host, numHosts),
 	}
 
 	colors := []string{
@@ -240,7 +422,7 @@ func CreateConfig(name string) error {
 	}
 
 	conf.Monitor.Height = gtr.IntOr("Monitor Height", 20)
-	conf.Monitor.Width = gtr.IntOr("Monitor Width", 60)
+	conf.Monitor.Width = gtr.IntOr("Monitor Width", 120)
 	conf.Monitor.GoArch = gtr.Select("Architecture", []string{
 		"386",
 		"amd64",
@@ -249,9 +431,16 @@ func CreateConfig(name string) error {
 	}, "arm64")
 	conf.Hosts = make([]*host, numHosts)
 
-	agentPort := gtr.IntOr("Agent Port", 20202)
-	agentProto := gtr.Select(
-		"Agent Protocol", []string{"http", "https"}, "http")
+	conf.Defaults.Executer = executer{
+		SshPort:  22,
+		UserName: cmnUser,
+		Password: cmnPwd,
+	}
+	conf.Defaults.Agent = agent{
+		Port: gtr.IntOr("Agent Port", defaultAgentPort),
+		Protocol: gtr.Select("Agent Protocol",
+			[]string{"http", "https"}, defaultAgentProtocol),
+	}
 
 	fmt.Println()
 	for i := 0; i < numHosts; i++ {
@@ -266,14 +455,7 @@ func CreateConfig(name string) error {
 					Name: strings.TrimSpace(parts[0]),
 					Host: strings.TrimSpace(parts[1]),
 					Executer: executer{
-						SshPort:  22,
-						Color:    colors[i%(len(colors)-1)],
-						UserName: cmnUser,
-						Password: cmnPwd,
-					},
-					Agent: agent{
-						Port:     agentPort,
-						Protocol: agentProto,
+						Color: colors[i%(len(colors)-1)],
 					},
 				}
 				if !useCmnUser {
@@ -312,7 +494,7 @@ func CreateConfig(name string) error {
 
 		opts := provider.ExecuterConfig().Opts
 		for _, opt := range opts {
-			opt.AuthMehod = xcutr.SshAuthPassword
+			opt.AuthMethod = xcutr.SshAuthPassword
 		}
 
 		if err := xcutr.CopyId(opts); err != nil {
@@ -335,8 +517,9 @@ func CreateConfigWithDefaults(name string) error {
 	numHosts := gtr.Int("Number of Hosts")
 
 	conf := PiclConfig{
-		Name:  name,
-		Hosts: make([]*host, numHosts),
+		Version: CurrentVersion,
+		Name:    name,
+		Hosts:   make([]*host, numHosts),
 	}
 
 	colors := []string{
@@ -355,9 +538,21 @@ func CreateConfigWithDefaults(name string) error {
 
 	conf.Monitor = monitor{
 		Height: 20,
-		Width:  60,
+		Width:  120,
 		GoArch: "arm64",
 	}
+	conf.Defaults = defaults{
+		Executer: executer{
+			SshPort:    22,
+			UserName:   cmnUser,
+			Password:   cmnPwd,
+			AuthMethod: xcutr.SshAuthPublicKey,
+		},
+		Agent: agent{
+			Port:     defaultAgentPort,
+			Protocol: defaultAgentProtocol,
+		},
+	}
 	conf.Hosts = make([]*host, numHosts)
 
 	fmt.Println()
@@ -373,15 +568,7 @@ func CreateConfigWithDefaults(name string) error {
 					Name: strings.TrimSpace(parts[0]),
 					Host: strings.TrimSpace(parts[1]),
 					Executer: executer{
-						SshPort:   22,
-						Color:     colors[i%(len(colors)-1)],
-						UserName:  cmnUser,
-						Password:  cmnPwd,
-						AuthMehod: xcutr.SshAuthPublicKey,
-					},
-					Agent: agent{
-						Port:     20202,
-						Protocol: "http",
+						Color: colors[i%(len(colors)-1)],
 					},
 				}
 				conf.Hosts[i] = host
@@ -419,7 +606,7 @@ func CreateConfigWithDefaults(name string) error {
 func CopySshId(provider Provider) error {
 	opts := provider.ExecuterConfig().Opts
 	for _, opt := range opts {
-		opt.AuthMehod = xcutr.SshAuthPassword
+		opt.AuthMethod = xcutr.SshAuthPassword
 	}
 
 	if err := xcutr.CopyId(opts); err != nil {
@@ -435,31 +622,55 @@ func generateConfig(
 		return errx.Wrap(err)
 	}
 
-	ext := ".config.json"
-	if encrypt {
-		ext = ".config.json.enc"
-	}
 	dir := filepath.Join(iox.MustGetUserHome(), ".picl")
 	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
 		return errx.Errf(err, "failed to create config dir at '%s'", dir)
 	}
 
-	path := filepath.Join(dir, configName+ext)
-	configFile, err := os.Create(path)
-	if err != nil {
-		return errx.Errf(err, "failed to create config file at '%s'", path)
+	return writeConfigFile(
+		configPath(configName, encrypt), jsonData, encrypt, pw)
+}
+
+func configPath(configName string, encrypted bool) string {
+	ext := ".config.json"
+	if encrypted {
+		ext = ".config.json.enc"
 	}
-	defer configFile.Close()
+	return filepath.Join(iox.MustGetUserHome(), ".picl", configName+ext)
+}
 
+// writeConfigFile - writes the config to a temporary file and renames it, so
+// that a failure while writing does not leave a truncated config behind
+func writeConfigFile(
+	path string, jsonData []byte, encrypt bool, pw string) error {
 	if encrypt {
+		var err error
 		jsonData, err = iox.NewCryptor(pw).Encrypt(jsonData)
 		if err != nil {
 			return errx.Wrap(err)
 		}
-		_, err = configFile.Write(jsonData)
-		return errx.Wrap(err)
 	}
 
-	_, err = configFile.WriteString(string(jsonData))
-	return errx.Wrap(err)
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return errx.Errf(err, "failed to create config file at '%s'", path)
+	}
+	defer os.Remove(tmp.Name())
+
+	// Temporary files are only readable by the owner, an existing config
+	// keeps its permissions
+	if stat, err := os.Stat(path); err == nil {
+		_ = tmp.Chmod(stat.Mode().Perm())
+	}
+	if _, err := tmp.Write(jsonData); err != nil {
+		tmp.Close()
+		return errx.Errf(err, "failed to write config file '%s'", tmp.Name())
+	}
+	if err := tmp.Close(); err != nil {
+		return errx.Errf(err, "failed to write config file '%s'", tmp.Name())
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return errx.Errf(err, "failed to replace config file '%s'", path)
+	}
+	return nil
 }
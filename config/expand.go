@@ -0,0 +1,127 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/varunamachi/libx/errx"
+)
+
+var (
+	ErrUndefinedEnvVar = errors.New("config.env.undefined")
+	ErrInvalidRange    = errors.New("config.host.invalidRange")
+)
+
+const (
+	indexPlaceholder = "{{index}}"
+
+	// maxRangeHosts - most hosts a single range can expand to, so that a
+	// typo like pi[1-10000] does not create thousands of hosts
+	maxRangeHosts = 256
+)
+
+var (
+	// Matches ${VAR} and ${VAR:-default}, along with $${...} which stands
+	// for a literal ${...}
+	envVarRegex = regexp.MustCompile(
+		`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+	// Matches host names of the form prefix[01-12]suffix
+	hostRangeRegex = regexp.MustCompile(`^(.*)\[(\d+)-(\d+)\](.*)$`)
+)
+
+// expandEnv - replaces ${VAR} and ${VAR:-default} references in the raw
+// config with values from the environment, $${VAR} is left as ${VAR}. Values
+// are JSON escaped, so references only work inside JSON strings. Numeric and
+// boolean fields such as sshPort cannot be given from the environment.
+// Referring to an undefined variable without a default is an error
+func expandEnv(data []byte) ([]byte, error) {
+	var err error
+	out := envVarRegex.ReplaceAllFunc(data, func(match []byte) []byte {
+		if match[1] == '$' {
+			return match[1:]
+		}
+		parts := envVarRegex.FindSubmatch(match)
+		name := string(parts[1])
+		hasDefault := len(parts[2]) != 0
+
+		// Like the shell, the default is used for empty values as well
+		val, found := os.LookupEnv(name)
+		if !found || (hasDefault && val == "") {
+			if !hasDefault {
+				if err == nil {
+					err = errx.Errf(ErrUndefinedEnvVar,
+						"config refers to undefined environment variable '%s'",
+						name)
+				}
+				return match
+			}
+			// Default is part of the JSON string, so it is already escaped
+			return parts[3]
+		}
+
+		escaped, _ := json.Marshal(val)
+		return escaped[1 : len(escaped)-1]
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// expandHosts - expands host entries whose name has a range of the form
+// pi[01-12] into one entry per number in the range. The {{index}}
+// placeholder in the host address is replaced with the number. Leading zeros
+// in the range start decide the width of the number in the name. A range can
+// have at most maxRangeHosts numbers
+func expandHosts(hosts []*host) ([]*host, error) {
+	expanded := make([]*host, 0, len(hosts))
+	for _, h := range hosts {
+		if h == nil {
+			continue
+		}
+
+		parts := hostRangeRegex.FindStringSubmatch(h.Name)
+		if parts == nil {
+			expanded = append(expanded, h)
+			continue
+		}
+
+		start, err := strconv.Atoi(parts[2])
+		if err != nil {
+			return nil, errx.Errf(err, "invalid range in host '%s'", h.Name)
+		}
+		end, err := strconv.Atoi(parts[3])
+		if err != nil {
+			return nil, errx.Errf(err, "invalid range in host '%s'", h.Name)
+		}
+		if start > end {
+			return nil, errx.Errf(ErrInvalidRange,
+				"range start is greater than end in host '%s'", h.Name)
+		}
+		if end-start+1 > maxRangeHosts {
+			return nil, errx.Errf(ErrInvalidRange,
+				"range in host '%s' has %d hosts, at most %d are allowed",
+				h.Name, end-start+1, maxRangeHosts)
+		}
+
+		width := 0
+		if strings.HasPrefix(parts[2], "0") {
+			width = len(parts[2])
+		}
+
+		for idx := start; idx <= end; idx++ {
+			hc := *h
+			hc.Name = fmt.Sprintf("%s%0*d%s", parts[1], width, idx, parts[4])
+			hc.Host = strings.ReplaceAll(
+				h.Host, indexPlaceholder, strconv.Itoa(idx))
+			expanded = append(expanded, &hc)
+		}
+	}
+	return expanded, nil
+}
@@ -0,0 +1,177 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("PICL_T_USER", "pi")
+	t.Setenv("PICL_T_SUBNET", "10.0.1")
+	t.Setenv("PICL_T_EMPTY", "")
+	t.Setenv("PICL_T_QUOTED", `say "hi" \ bye`)
+	t.Setenv("PICL_T_NEWLINE", "a\nb")
+	t.Setenv("PICL_T_REF", "${PICL_T_USER}")
+
+	tests := []struct {
+		name  string
+		in    string
+		want  string
+		fails bool
+	}{
+		{name: "plain", in: "${PICL_T_USER}", want: "pi"},
+		{name: "in text", in: "${PICL_T_SUBNET}.{{index}}",
+			want: "10.0.1.{{index}}"},
+		{name: "many", in: "${PICL_T_USER}@${PICL_T_SUBNET}.1",
+			want: "pi@10.0.1.1"},
+		{name: "set with default", in: "${PICL_T_USER:-admin}", want: "pi"},
+		{name: "unset with default", in: "${PICL_T_UNSET:-admin}",
+			want: "admin"},
+		{name: "empty with default", in: "${PICL_T_EMPTY:-admin}",
+			want: "admin"},
+		{name: "empty without default", in: "x${PICL_T_EMPTY}x", want: "xx"},
+		{name: "empty default", in: "x${PICL_T_UNSET:-}x", want: "xx"},
+		{name: "default with spaces", in: "${PICL_T_UNSET:-a b/c}",
+			want: "a b/c"},
+		{name: "default keeps escapes", in: `${PICL_T_UNSET:-a\"b}`,
+			want: `a"b`},
+		{name: "value is escaped", in: "${PICL_T_QUOTED}",
+			want: `say "hi" \ bye`},
+		{name: "newline is escaped", in: "${PICL_T_NEWLINE}", want: "a\nb"},
+		{name: "value is not expanded again", in: "${PICL_T_REF}",
+			want: "${PICL_T_USER}"},
+		{name: "escaped reference", in: "$${PICL_T_USER}",
+			want: "${PICL_T_USER}"},
+		{name: "escaped undefined", in: "$${PICL_T_UNSET}",
+			want: "${PICL_T_UNSET}"},
+		{name: "escaped with default", in: "$${PICL_T_UNSET:-x}",
+			want: "${PICL_T_UNSET:-x}"},
+		{name: "no braces", in: "$PICL_T_USER", want: "$PICL_T_USER"},
+		{name: "invalid name", in: "${1ABC}", want: "${1ABC}"},
+		{name: "undefined", in: "${PICL_T_UNSET}", fails: true},
+		{name: "undefined among others", in: "${PICL_T_USER}${PICL_T_UNSET}",
+			fails: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Inputs are as written in a JSON string of the config
+			out, err := expandEnv([]byte(`"` + test.in + `"`))
+			if test.fails {
+				if !errors.Is(err, ErrUndefinedEnvVar) {
+					t.Fatalf("expected ErrUndefinedEnvVar, got: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got string
+			if err := json.Unmarshal(out, &got); err != nil {
+				t.Fatalf("expansion gave invalid JSON %s: %v", out, err)
+			}
+			if got != test.want {
+				t.Errorf("expected '%s', got '%s'", test.want, got)
+			}
+		})
+	}
+}
+
+func TestExpandEnvOnlyInStrings(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("PICL_T_PORT", "2222")
+
+	// Numbers cannot come from the environment, quoting them makes them
+	// strings which do not fit the numeric fields
+	_, err := New([]byte(`{"version": 1, "hosts": [
+		{"name": "pi1", "host": "h", "executer": {"sshPort": "${PICL_T_PORT}"}}
+	]}`))
+	if err == nil {
+		t.Fatal("expected a string for a numeric field to fail")
+	}
+}
+
+func hostNames(hosts []*host) []string {
+	names := make([]string, len(hosts))
+	for idx, h := range hosts {
+		names[idx] = h.Name + "=" + h.Host
+	}
+	return names
+}
+
+func TestExpandHosts(t *testing.T) {
+	many := make([]string, 0, maxRangeHosts)
+	for idx := 1; idx <= maxRangeHosts; idx++ {
+		many = append(many, fmt.Sprintf("n%d=h", idx))
+	}
+
+	tests := []struct {
+		name  string
+		host  string
+		addr  string
+		want  []string
+		fails bool
+	}{
+		{name: "no range", host: "pi", addr: "10.0.0.1",
+			want: []string{"pi=10.0.0.1"}},
+		{name: "padded", host: "pi[01-03]", addr: "10.0.0.{{index}}",
+			want: []string{
+				"pi01=10.0.0.1", "pi02=10.0.0.2", "pi03=10.0.0.3",
+			}},
+		{name: "padded past width", host: "pi[08-10]", addr: "h{{index}}",
+			want: []string{"pi08=h8", "pi09=h9", "pi10=h10"}},
+		{name: "wider padding", host: "pi[008-010]", addr: "h",
+			want: []string{"pi008=h", "pi009=h", "pi010=h"}},
+		{name: "unpadded", host: "pi[9-11]", addr: "h",
+			want: []string{"pi9=h", "pi10=h", "pi11=h"}},
+		{name: "zero start", host: "pi[0-2]", addr: "h{{index}}",
+			want: []string{"pi0=h0", "pi1=h1", "pi2=h2"}},
+		{name: "suffix", host: "rack[1-2]-a", addr: "r{{index}}.lan",
+			want: []string{"rack1-a=r1.lan", "rack2-a=r2.lan"}},
+		{name: "single", host: "pi[5-5]", addr: "h",
+			want: []string{"pi5=h"}},
+		{name: "placeholder twice", host: "pi[1-1]",
+			addr: "{{index}}-{{index}}", want: []string{"pi1=1-1"}},
+		{name: "largest", host: "n[1-256]", addr: "h", want: many},
+		{name: "too large", host: "n[0-256]", addr: "h", fails: true},
+		{name: "huge", host: "n[1-1000000000]", addr: "h", fails: true},
+		{name: "reversed", host: "pi[3-1]", addr: "h", fails: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hosts, err := expandHosts([]*host{
+				nil,
+				{Name: test.host, Host: test.addr},
+			})
+			if test.fails {
+				if !errors.Is(err, ErrInvalidRange) {
+					t.Fatalf("expected ErrInvalidRange, got: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := hostNames(hosts)
+			if fmt.Sprint(got) != fmt.Sprint(test.want) {
+				t.Errorf("expected %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestExpandHostsCopies(t *testing.T) {
+	hosts, err := expandHosts([]*host{{
+		Name:     "pi[1-2]",
+		Host:     "h{{index}}",
+		Executer: executer{UserName: "pi"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts[0].Executer.UserName = "changed"
+	if hosts[1].Executer.UserName != "pi" {
+		t.Error("expanded hosts share their settings")
+	}
+}
@@ -0,0 +1,212 @@
+package config
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/varunamachi/libx/errx"
+	"github.com/varunamachi/libx/httpx"
+	"github.com/varunamachi/libx/iox"
+)
+
+const (
+	defaultAgentPort     = 20202
+	defaultAgentProtocol = "http"
+	secretMask           = "********"
+)
+
+// defaults - settings shared by all the hosts. Exists at cluster level in
+// each config and optionally in ~/.picl/global.json which applies to all the
+// configs. Values given in a host override the cluster defaults, which in
+// turn override the global defaults
+type defaults struct {
+	Executer executer `json:"executer"`
+	Agent    agent    `json:"agent"`
+}
+
+func (ex *executer) inherit(parent *executer) {
+	if ex.SshPort == 0 {
+		ex.SshPort = parent.SshPort
+	}
+	if ex.UserName == "" {
+		ex.UserName = parent.UserName
+	}
+	if ex.Password == "" {
+		ex.Password = parent.Password
+	}
+	if ex.AuthMethod == "" {
+		ex.AuthMethod = parent.AuthMethod
+	}
+	if ex.Color == "" {
+		ex.Color = parent.Color
+	}
+}
+
+func (ag *agent) inherit(parent *agent) {
+	if ag.Port == 0 {
+		ag.Port = parent.Port
+	}
+	if ag.Protocol == "" {
+		ag.Protocol = parent.Protocol
+	}
+	if ag.AuthData == nil {
+		ag.AuthData = parent.AuthData
+	}
+	if ag.Mode == "" {
+		ag.Mode = parent.Mode
+	}
+	if ag.PushUser == "" {
+		ag.PushUser = parent.PushUser
+	}
+	if ag.PollIntervalMs == 0 {
+		ag.PollIntervalMs = parent.PollIntervalMs
+	}
+	if ag.PollTimeoutMs == 0 {
+		ag.PollTimeoutMs = parent.PollTimeoutMs
+	}
+}
+
+func (h *host) inherit(dfs *defaults) {
+	h.Executer.inherit(&dfs.Executer)
+	h.Agent.inherit(&dfs.Agent)
+}
+
+func globalConfigPath() string {
+	return filepath.Join(iox.MustGetUserHome(), ".picl", "global.json")
+}
+
+// loadGlobalDefaults - reads ~/.picl/global.json if it exists, an empty set of
+// defaults is returned otherwise
+func loadGlobalDefaults() (*defaults, error) {
+	dfs := &defaults{}
+	path := globalConfigPath()
+	if !iox.ExistsAsFile(path) {
+		return dfs, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errx.Errf(err, "failed to read global config '%s'", path)
+	}
+	data, err = expandEnv(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, dfs); err != nil {
+		return nil, errx.Errf(err, "failed to parse global config '%s'", path)
+	}
+	return dfs, nil
+}
+
+// resolve - gives copies of the hosts with all the inherited values filled in
+func (cfg *PiclConfig) resolve(global *defaults) []*host {
+	builtin := &defaults{
+		Agent: agent{
+			Port:     defaultAgentPort,
+			Protocol: defaultAgentProtocol,
+		},
+	}
+
+	resolved := make([]*host, 0, len(cfg.Hosts))
+	for _, h := range cfg.Hosts {
+		if h == nil {
+			continue
+		}
+		hc := *h
+		hc.inherit(&cfg.Defaults)
+		hc.inherit(global)
+		hc.inherit(builtin)
+		resolved = append(resolved, &hc)
+	}
+	return resolved
+}
+
+// Show - writes the named config as JSON to the given writer. If resolved is
+// true, the hosts are printed with defaults applied and ranges expanded.
+// Passwords are masked unless showSecrets is true
+func Show(
+	cfgName string, resolved, showSecrets bool, writer io.Writer) error {
+	cf, err := loadConfigFile(cfgName)
+	if err != nil {
+		return err
+	}
+
+	data := cf.data
+	if resolved {
+		if data, err = expandEnv(data); err != nil {
+			return err
+		}
+	}
+
+	cfg := PiclConfig{}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return errx.Errf(err, "failed to parse configuration %s", cfgName)
+	}
+
+	if resolved {
+		global, err := loadGlobalDefaults()
+		if err != nil {
+			return err
+		}
+		if cfg.Hosts, err = expandHosts(cfg.Hosts); err != nil {
+			return err
+		}
+		cfg.Hosts = cfg.resolve(global)
+		cfg.Defaults = defaults{}
+	}
+
+	if !showSecrets {
+		cfg.maskSecrets()
+	}
+
+	out, err := json.MarshalIndent(&cfg, "", "    ")
+	if err != nil {
+		return errx.Wrap(err)
+	}
+	_, err = writer.Write(append(out, '\n'))
+	return errx.Wrap(err)
+}
+
+func (cfg *PiclConfig) maskSecrets() {
+	if cfg.Defaults.Executer.Password != "" {
+		cfg.Defaults.Executer.Password = secretMask
+	}
+	cfg.Defaults.Agent.AuthData = maskAuthData(cfg.Defaults.Agent.AuthData)
+	for _, h := range cfg.Hosts {
+		if h == nil {
+			continue
+		}
+		if h.Executer.Password != "" {
+			h.Executer.Password = secretMask
+		}
+		h.Agent.AuthData = maskAuthData(h.Agent.AuthData)
+	}
+	for _, user := range cfg.Monitor.Users {
+		if user.Password != "" {
+			user.Password = secretMask
+		}
+	}
+	if cfg.Monitor.Alerts != nil {
+		for _, nc := range cfg.Monitor.Alerts.Notifiers {
+			if nc.Smtp != nil && nc.Smtp.Password != "" {
+				nc.Smtp.Password = secretMask
+			}
+		}
+	}
+}
+
+func maskAuthData(ad *httpx.AuthData) *httpx.AuthData {
+	if ad == nil {
+		return nil
+	}
+	masked := httpx.AuthData{}
+	for key, val := range *ad {
+		masked[key] = val
+	}
+	if _, found := masked["password"]; found {
+		masked["password"] = secretMask
+	}
+	return &masked
+}
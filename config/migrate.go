@@ -0,0 +1,237 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/varunamachi/libx/errx"
+	"github.com/varunamachi/libx/iox"
+)
+
+// CurrentVersion - version of the config schema understood by this build.
+// Bump it and add a migration whenever the JSON representation changes
+const CurrentVersion = 1
+
+var (
+	ErrUnsupportedVersion = errors.New("config.version.unsupported")
+)
+
+type migration struct {
+	from  int
+	desc  string
+	apply func(cfg map[string]interface{}) error
+}
+
+// migrations - ordered list of migrations, migration at index i upgrades a
+// config from version i to i+1
+var migrations = []*migration{
+	{
+		from:  0,
+		desc:  "drop the unused executer.authData",
+		apply: migrateV0ToV1,
+	},
+}
+
+func migrateV0ToV1(cfg map[string]interface{}) error {
+	hosts, _ := cfg["hosts"].([]interface{})
+	for _, h := range hosts {
+		hm, ok := h.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		ex, ok := hm["executer"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		delete(ex, "authData")
+	}
+	return nil
+}
+
+func versionOf(cfg map[string]interface{}) int {
+	// JSON numbers are decoded as float64
+	ver, _ := cfg["version"].(float64)
+	return int(ver)
+}
+
+// Migrate - upgrades the given raw config to the current schema version. The
+// second return value tells if any migration was applied
+func Migrate(data []byte) ([]byte, bool, error) {
+	raw := map[string]interface{}{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, false, errx.Errf(err,
+			"failed to parse config for migration")
+	}
+
+	version := versionOf(raw)
+	if version < 0 {
+		return nil, false, errx.Errf(ErrUnsupportedVersion,
+			"config version %d is invalid", version)
+	}
+	if version > CurrentVersion {
+		return nil, false, errx.Errf(ErrUnsupportedVersion,
+			"config version %d is newer than supported version %d",
+			version, CurrentVersion)
+	}
+	if version == CurrentVersion {
+		return data, false, nil
+	}
+
+	for ; version < CurrentVersion; version++ {
+		if version >= len(migrations) || migrations[version].from != version {
+			return nil, false, errx.Errf(ErrUnsupportedVersion,
+				"no migration from config version %d", version)
+		}
+		mg := migrations[version]
+		log.Info().
+			Int("from", mg.from).
+			Int("to", mg.from+1).
+			Msg("migrating config: " + mg.desc)
+		if err := mg.apply(raw); err != nil {
+			return nil, false, errx.Errf(err,
+				"failed to migrate config from version %d", mg.from)
+		}
+		raw["version"] = mg.from + 1
+	}
+
+	// Round trip through the struct so that the output has the same layout
+	// as the generated configs
+	migrated, err := json.Marshal(raw)
+	if err != nil {
+		return nil, false, errx.Wrap(err)
+	}
+	cfg := PiclConfig{}
+	if err := json.Unmarshal(migrated, &cfg); err != nil {
+		return nil, false, errx.Errf(err, "failed to load migrated config")
+	}
+	out, err := json.MarshalIndent(&cfg, "", "    ")
+	if err != nil {
+		return nil, false, errx.Wrap(err)
+	}
+	return out, true, nil
+}
+
+// MigrateFile - migrates the named config in place. The original file is
+// backed up next to it before being overwritten
+func MigrateFile(cfgName string) error {
+	cf, err := readConfigFile(cfgName)
+	if err != nil {
+		return err
+	}
+	migrated, err := cf.migrate()
+	if err != nil {
+		return err
+	}
+	if !migrated {
+		log.Info().Str("config", cfgName).Msg("config is already up to date")
+	}
+	return nil
+}
+
+type configFile struct {
+	name      string
+	path      string
+	encrypted bool
+	password  string
+	data      []byte
+}
+
+func readConfigFile(cfgName string) (*configFile, error) {
+	cf := &configFile{
+		name: cfgName,
+		path: configPath(cfgName, false),
+	}
+	if !iox.ExistsAsFile(cf.path) {
+		cf.path = configPath(cfgName, true)
+		cf.encrypted = true
+	}
+	if !iox.ExistsAsFile(cf.path) {
+		return nil, errx.Errf(os.ErrNotExist,
+			"could not find configuration %s", cfgName)
+	}
+
+	data, err := os.ReadFile(cf.path)
+	if err != nil {
+		return nil, errx.Errf(err, "failed to read configuration %s", cfgName)
+	}
+
+	if cf.encrypted {
+		cf.password = iox.AskPassword("Please Enter Config Password")
+		data, err = iox.NewCryptor(cf.password).Decrypt(data)
+		if err != nil {
+			return nil, errx.Errf(err,
+				"failed to decrypt configuration %s", cfgName)
+		}
+	}
+	cf.data = data
+	return cf, nil
+}
+
+// migrate - upgrades the loaded config if required and writes it back after
+// backing up the original file. Only the migrate command writes configs back,
+// loading upgrades them in memory
+func (cf *configFile) migrate() (bool, error) {
+	migrated, changed, err := Migrate(cf.data)
+	if err != nil {
+		return false, err
+	}
+	if !changed {
+		return false, nil
+	}
+
+	orig, err := os.ReadFile(cf.path)
+	if err != nil {
+		return false, errx.Errf(err, "failed to read config for backup")
+	}
+	backupPath := cf.path + "_" + time.Now().Format("20060102_150405")
+	if err := os.WriteFile(backupPath, orig, 0600); err != nil {
+		return false, errx.Errf(err,
+			"failed to back up config to '%s'", backupPath)
+	}
+
+	if err := writeConfigFile(
+		cf.path, migrated, cf.encrypted, cf.password); err != nil {
+		return false, err
+	}
+	cf.data = migrated
+
+	log.Info().
+		Str("config", cf.name).
+		Str("backup", backupPath).
+		Msg("config migrated to current version")
+	return true, nil
+}
+
+// ListConfigs - gives names of all the configs, plain or encrypted, present
+// in ~/.picl
+func ListConfigs() ([]string, error) {
+	dir := filepath.Join(iox.MustGetUserHome(), ".picl")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errx.Errf(err, "failed to list configs in '%s'", dir)
+	}
+
+	names := make([]string, 0, len(entries))
+	seen := map[string]bool{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		for _, ext := range []string{".config.json", ".config.json.enc"} {
+			if base, found := strings.CutSuffix(name, ext); found {
+				if !seen[base] {
+					names = append(names, base)
+					seen[base] = true
+				}
+				break
+			}
+		}
+	}
+	return names, nil
+}
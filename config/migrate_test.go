@@ -0,0 +1,205 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/varunamachi/picl/xcutr"
+)
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestMigrateV0(t *testing.T) {
+	orig := readFixture(t, "v0.config.json")
+	migrated, changed, err := Migrate(orig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Fatal("v0 config was not migrated")
+	}
+
+	raw := map[string]interface{}{}
+	if err := json.Unmarshal(migrated, &raw); err != nil {
+		t.Fatal(err)
+	}
+	if ver := versionOf(raw); ver != CurrentVersion {
+		t.Errorf("expected version %d, got %d", CurrentVersion, ver)
+	}
+	if strings.Contains(string(migrated), "keyPath") {
+		t.Error("executer.authData was not dropped")
+	}
+
+	// Migrating again does nothing
+	again, changed, err := Migrate(migrated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed || !bytes.Equal(again, migrated) {
+		t.Error("migrating a current config changed it")
+	}
+
+	t.Setenv("HOME", t.TempDir())
+	provider, err := New(migrated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := provider.ExecuterConfig().Opts
+	if len(opts) != 2 {
+		t.Fatalf("expected 2 hosts, got %d", len(opts))
+	}
+	want := []xcutr.SshConnOpts{
+		{Name: "pi1", Host: "192.168.1.11", Port: 22, UserName: "pi",
+			AuthMethod: xcutr.SshAuthPublicKey},
+		{Name: "pi2", Host: "192.168.1.12", Port: 2222, UserName: "admin",
+			AuthMethod: xcutr.SshAuthPassword, Password: "secret"},
+	}
+	for idx, opt := range opts {
+		if opt.Name != want[idx].Name || opt.Host != want[idx].Host ||
+			opt.Port != want[idx].Port ||
+			opt.UserName != want[idx].UserName ||
+			opt.AuthMethod != want[idx].AuthMethod ||
+			opt.Password != want[idx].Password {
+			t.Errorf("host %d: expected %+v, got %+v", idx, want[idx], *opt)
+		}
+	}
+
+	agents := provider.MonitorConfig().AgentConfig
+	if agents[1].Address != "https://192.168.1.12:20202" {
+		t.Errorf("unexpected agent address '%s'", agents[1].Address)
+	}
+	if agents[1].AuthData == nil || (*agents[1].AuthData)["userId"] != "agent" {
+		t.Errorf("agent auth data was not kept: %v", agents[1].AuthData)
+	}
+}
+
+func TestMigrateUnsupportedVersion(t *testing.T) {
+	for _, cfg := range []string{
+		`{"version": 99, "hosts": []}`,
+		`{"version": -1, "hosts": []}`,
+	} {
+		_, _, err := Migrate([]byte(cfg))
+		if !errors.Is(err, ErrUnsupportedVersion) {
+			t.Fatalf("%s: expected ErrUnsupportedVersion, got: %v", cfg, err)
+		}
+	}
+}
+
+func TestMigrationsInOrder(t *testing.T) {
+	if len(migrations) != CurrentVersion {
+		t.Fatalf("expected %d migrations, got %d",
+			CurrentVersion, len(migrations))
+	}
+	for idx, mg := range migrations {
+		if mg.from != idx {
+			t.Errorf("migration at %d is from version %d", idx, mg.from)
+		}
+	}
+}
+
+func TestLoadDoesNotRewriteOldConfig(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	dir := filepath.Join(home, ".picl")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	orig := readFixture(t, "v0.config.json")
+	path := filepath.Join(dir, "old.config.json")
+	if err := os.WriteFile(path, orig, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cf, err := loadConfigFile("old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(cf.data, orig) {
+		t.Error("loaded config was not migrated in memory")
+	}
+	onDisk, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(onDisk, orig) {
+		t.Error("loading the config rewrote the file")
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("loading the config created files: %v", entries)
+	}
+}
+
+func TestMigrateFile(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	dir := filepath.Join(home, ".picl")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	orig := readFixture(t, "v0.config.json")
+	path := filepath.Join(dir, "old.config.json")
+	if err := os.WriteFile(path, orig, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := MigrateFile("old"); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, changed, err := Migrate(data); err != nil || changed {
+		t.Errorf("migrated file is not current, changed: %v, err: %v",
+			changed, err)
+	}
+	if stat, err := os.Stat(path); err != nil || stat.Mode().Perm() != 0640 {
+		t.Errorf("migrated file did not keep its permissions: %v", stat)
+	}
+
+	// Besides the config only the backup with the original content is left
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected the config and its backup, got: %v", entries)
+	}
+	for _, entry := range entries {
+		if entry.Name() == "old.config.json" {
+			continue
+		}
+		if !strings.HasPrefix(entry.Name(), "old.config.json_") {
+			t.Fatalf("unexpected file '%s'", entry.Name())
+		}
+		backup, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(backup, orig) {
+			t.Error("backup does not have the original content")
+		}
+	}
+
+	// Nothing to do the second time, no further backups
+	if err := MigrateFile("old"); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("migrating a current config created files: %v", entries)
+	}
+}
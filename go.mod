@@ -9,6 +9,7 @@ replace github.com/varunamachi/libx => ../libx
 require (
 	github.com/fatih/color v1.17.0
 	github.com/gizak/termui/v3 v3.1.0
+	github.com/golang-jwt/jwt v3.2.2+incompatible
 	github.com/google/uuid v1.6.0
 	github.com/labstack/echo/v4 v4.12.0
 	github.com/pkg/sftp v1.13.6
@@ -17,6 +18,7 @@ require (
 	github.com/urfave/cli/v2 v2.27.4
 	golang.org/x/crypto v0.26.0
 	golang.org/x/sync v0.8.0
+	golang.org/x/sys v0.24.0
 )
 
 require (
@@ -25,7 +27,6 @@ require (
 )
 
 require (
-	github.com/golang-jwt/jwt v3.2.2+incompatible // indirect
 	github.com/lib/pq v1.10.9 // indirect
 	github.com/lufia/plan9stats v0.0.0-20240513124658-fba389f38bae // indirect
 	github.com/power-devops/perfstat v0.0.0-20240221224432-82ca36839d55 // indirect
@@ -53,7 +54,6 @@ require (
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
 	github.com/valyala/fasttemplate v1.2.2 // indirect
 	golang.org/x/net v0.28.0 // indirect
-	golang.org/x/sys v0.24.0 // indirect
 	golang.org/x/term v0.23.0 // indirect
 	golang.org/x/text v0.17.0 // indirect
 )
@@ -1,8 +1,11 @@
 package mon
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"os"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/rs/zerolog/log"
@@ -11,18 +14,75 @@ import (
 	"github.com/varunamachi/libx/httpx"
 )
 
-func RunAgent(address string) error {
-	server := echo.New()
-	server.GET("/api/v0/cur", handleSysInfo)
-	server.GET("/api/v0/host", hostInfo)
-	server.GET("/", func(etx echo.Context) error {
-		return etx.String(http.StatusOK, "42")
+// RunAgent - runs the agent REST service on given port. Users, if given, are
+// allowed to login and use the endpoints that change the state of the node
+func RunAgent(name string, port uint32, users []*User) error {
+	server := httpx.NewServer(os.Stdout, nil)
+	server.WithAPIs(getAuthEndpoints(users)...)
+	server.WithAPIs(getAgentEndpoints()...)
+	server.WithAPIs(secureAll(users, getLogEndpoints()...)...)
+	server.WithAPIs(secure(users, false, getServiceEndpoints(users)...)...)
+	server.WithPages(getAgentMetricsEndpoint(name))
+	server.WithPages(&httpx.Endpoint{
+		Method:   echo.GET,
+		Path:     "/",
+		Category: "agent",
+		Desc:     "Check if the agent is running",
+		Handler: func(etx echo.Context) error {
+			return etx.String(http.StatusOK, "42")
+		},
 	})
-	return server.Start(address)
+	return server.Start(port)
+}
+
+// NewAgentClient - creates a client for the agent and logs in if the agent
+// requires authentication
+func NewAgentClient(
+	gtx context.Context,
+	conf *AgentConfig,
+	timeout time.Duration) (*httpx.Client, error) {
+	client := httpx.NewCustomClient(
+		conf.Address, "", httpx.DefaultTransport(), timeout)
+	if conf.AuthData != nil {
+		if err := Login(gtx, client, *conf.AuthData); err != nil {
+			return nil, errx.Errf(err,
+				"failed to login to agent '%s'", conf.Name)
+		}
+	}
+	return client, nil
+}
+
+func getAgentEndpoints() []*httpx.Endpoint {
+	return []*httpx.Endpoint{
+		{
+			Method:   echo.GET,
+			Path:     "/cur",
+			Category: "agent",
+			Desc:     "Get current resource usage of the node",
+			Version:  "v0",
+			Handler:  handleSysInfo,
+		},
+		{
+			Method:   echo.GET,
+			Path:     "/host",
+			Category: "agent",
+			Desc:     "Get information about the host",
+			Version:  "v0",
+			Handler:  hostInfo,
+		},
+		{
+			Method:   echo.GET,
+			Path:     "/procs",
+			Category: "agent",
+			Desc:     "Get processes using most CPU and memory",
+			Version:  "v0",
+			Handler:  handleProcs,
+		},
+	}
 }
 
 func handleSysInfo(etx echo.Context) error {
-	info, err := systemInfo(etx.Request().Context())
+	info, err := takeSample(etx.Request().Context())
 	if err != nil {
 		return errx.Wrap(err)
 	}
@@ -30,6 +90,21 @@ func handleSysInfo(etx echo.Context) error {
 	return httpx.SendJSON(etx, info)
 }
 
+// HostInfo - static information about the node
+type HostInfo struct {
+	Hostname        string `json:"hostname"`
+	HostId          string `json:"hostId"`
+	KernelArch      string `json:"kernalArch"`
+	Uptime          uint64 `json:"uptime"`
+	HumanUptime     string `json:"humanUptime"`
+	BootTime        uint64 `json:"bootTime"`
+	OS              string `json:"os"`
+	Platform        string `json:"platform"`
+	PlatformVersion string `json:"platformVersion"`
+	KernelVersion   string `json:"kernelVersion"`
+	Procs           uint64 `json:"procs"`
+}
+
 func hostInfo(etx echo.Context) error {
 	h, err := host.Info()
 	if err != nil {
@@ -49,11 +124,26 @@ func hostInfo(etx echo.Context) error {
 	humanUptime := fmt.Sprintf("%d Days, %d Hours, %d Minutes, %d Seconds",
 		days, hours, minute, seconds)
 
-	return httpx.SendJSON(etx, map[string]interface{}{
-		"hostname":    h.Hostname,
-		"hostId":      h.HostID,
-		"kernalArch":  h.KernelArch,
-		"uptime":      h.Uptime,
-		"humanUptime": humanUptime,
+	return httpx.SendJSON(etx, &HostInfo{
+		Hostname:        h.Hostname,
+		HostId:          h.HostID,
+		KernelArch:      h.KernelArch,
+		Uptime:          h.Uptime,
+		HumanUptime:     humanUptime,
+		BootTime:        h.BootTime,
+		OS:              h.OS,
+		Platform:        h.Platform,
+		PlatformVersion: h.PlatformVersion,
+		KernelVersion:   h.KernelVersion,
+		Procs:           h.Procs,
 	})
 }
+
+// GetHostInfo - gets static information about the node from its agent
+func GetHostInfo(gtx context.Context, client *httpx.Client) (*HostInfo, error) {
+	info := &HostInfo{}
+	if err := client.Get(gtx, "/api/v0/host").LoadClose(info); err != nil {
+		return nil, errx.Errf(err, "failed to get host information from agent")
+	}
+	return info, nil
+}
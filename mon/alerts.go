@@ -0,0 +1,428 @@
+package mon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+	"github.com/varunamachi/libx/errx"
+	"github.com/varunamachi/libx/httpx"
+)
+
+var (
+	ErrInvalidAlertRule = errors.New("mon.alert.invalidRule")
+	ErrUnknownNotifier  = errors.New("mon.alert.unknownNotifier")
+)
+
+const (
+	defaultNotifyTimeout = 10 * time.Second
+	notifyQueueSize      = 64
+)
+
+// AlertRule - condition evaluated against every sample of the nodes. The
+// expression is either '<metric> <op> <threshold>' with one of the history
+// metrics, or 'unreachable' for agents that do not respond. Either can be
+// followed by 'for <duration>' to fire only if the condition holds that long,
+// e.g. 'cpuTemp > 75 for 2m' or 'unreachable for 1m'. Rules apply to all the
+// nodes and notify all the notifiers unless Nodes or Notifiers are given. A
+// firing alert is notified again every RepeatSecs, only once if it is zero
+type AlertRule struct {
+	Name       string   `json:"name"`
+	Expr       string   `json:"expr"`
+	Nodes      []string `json:"nodes,omitempty"`
+	Notifiers  []string `json:"notifiers,omitempty"`
+	RepeatSecs int      `json:"repeatSecs,omitempty"`
+}
+
+type AlertConfig struct {
+	Rules     []*AlertRule      `json:"rules"`
+	Notifiers []*NotifierConfig `json:"notifiers"`
+}
+
+type AlertState string
+
+const (
+	AlertPending  AlertState = "pending"
+	AlertFiring   AlertState = "firing"
+	AlertResolved AlertState = "resolved"
+)
+
+// Alert - state of a rule for a node. Value is the last value of the metric,
+// not set for unreachable rules
+type Alert struct {
+	Rule  string     `json:"rule"`
+	Expr  string     `json:"expr"`
+	Node  string     `json:"node"`
+	State AlertState `json:"state"`
+	Value *float64   `json:"value,omitempty"`
+	Since time.Time  `json:"since"`
+	Time  time.Time  `json:"time"`
+}
+
+func (a *Alert) Summary() string {
+	msg := fmt.Sprintf("[%s] %s on %s: %s",
+		strings.ToUpper(string(a.State)), a.Rule, a.Node, a.Expr)
+	if a.Value != nil {
+		msg += fmt.Sprintf(" (value %.2f)", *a.Value)
+	}
+	return msg
+}
+
+type alertCond struct {
+	metric    int // index into historyMetrics, -1 for unreachable
+	op        string
+	threshold float64
+	dur       time.Duration
+}
+
+func parseAlertExpr(expr string) (*alertCond, error) {
+	fields := strings.Fields(expr)
+	cond := &alertCond{metric: -1}
+
+	if len(fields) >= 2 && fields[len(fields)-2] == "for" {
+		dur, err := time.ParseDuration(fields[len(fields)-1])
+		if err != nil || dur < 0 {
+			return nil, errx.Errf(ErrInvalidAlertRule,
+				"invalid duration in alert expression '%s'", expr)
+		}
+		cond.dur = dur
+		fields = fields[:len(fields)-2]
+	}
+
+	if len(fields) == 2 && fields[0] == "agent" {
+		fields = fields[1:]
+	}
+	if len(fields) == 1 && fields[0] == "unreachable" {
+		return cond, nil
+	}
+
+	if len(fields) != 3 {
+		return nil, errx.Errf(ErrInvalidAlertRule,
+			"alert expression '%s' should be of the form "+
+				"'<metric> <op> <threshold> [for <duration>]' or "+
+				"'unreachable [for <duration>]'", expr)
+	}
+	cond.metric = slices.Index(historyMetrics, fields[0])
+	if cond.metric < 0 {
+		return nil, errx.Errf(ErrInvalidAlertRule,
+			"unknown metric '%s' in alert expression, should be one of: %v",
+			fields[0], historyMetrics)
+	}
+	switch fields[1] {
+	case ">", ">=", "<", "<=", "==", "!=":
+		cond.op = fields[1]
+	default:
+		return nil, errx.Errf(ErrInvalidAlertRule,
+			"invalid operator '%s' in alert expression", fields[1])
+	}
+	var err error
+	cond.threshold, err = strconv.ParseFloat(fields[2], 64)
+	if err != nil {
+		return nil, errx.Errf(ErrInvalidAlertRule,
+			"invalid threshold '%s' in alert expression", fields[2])
+	}
+	return cond, nil
+}
+
+// eval - whether the condition holds for the response, known is false when
+// the response does not say anything about it
+func (ac *alertCond) eval(
+	resp *AgentResponse) (holds, known bool, val float64) {
+	if ac.metric < 0 {
+		return resp.Err != nil, true, 0
+	}
+	if resp.Err != nil || resp.Data == nil {
+		return false, false, 0
+	}
+	val = metricValues(resp.Data)[ac.metric]
+	if math.IsNaN(val) {
+		return false, false, 0
+	}
+	switch ac.op {
+	case ">":
+		holds = val > ac.threshold
+	case ">=":
+		holds = val >= ac.threshold
+	case "<":
+		holds = val < ac.threshold
+	case "<=":
+		holds = val <= ac.threshold
+	case "==":
+		holds = val == ac.threshold
+	case "!=":
+		holds = val != ac.threshold
+	}
+	return holds, true, val
+}
+
+// notifyQueue - alerts to a notifier are sent one after the other, so that a
+// resolved alert never overtakes the firing one
+type notifyQueue struct {
+	name     string
+	notifier Notifier
+	alerts   chan *Alert
+}
+
+func (nq *notifyQueue) run(gtx context.Context) {
+	for {
+		select {
+		case <-gtx.Done():
+			return
+		case alert := <-nq.alerts:
+			ctx, cancel := context.WithTimeout(gtx, defaultNotifyTimeout)
+			if err := nq.notifier.Notify(ctx, alert); err != nil {
+				log.Error().Err(err).
+					Str("notifier", nq.name).
+					Str("rule", alert.Rule).
+					Str("node", alert.Node).
+					Msg("failed to send alert notification")
+			}
+			cancel()
+		}
+	}
+}
+
+type alertRule struct {
+	*AlertRule
+	cond   *alertCond
+	queues []*notifyQueue
+}
+
+type alertKey struct {
+	rule string
+	node int
+}
+
+type activeAlert struct {
+	alert    Alert
+	notified time.Time
+}
+
+// alerter - evaluates the rules on every sample. An alert stays pending while
+// the condition has not held long enough and fires after that. Notifications
+// go out when an alert fires and when a fired alert resolves, so flapping
+// conditions and repeated samples do not flood the notifiers
+type alerter struct {
+	mutex  sync.Mutex
+	rules  []*alertRule
+	queues []*notifyQueue
+	agents []*AgentConfig
+	events *EventLog
+	active map[alertKey]*activeAlert
+}
+
+func newAlerter(
+	cfg *AlertConfig,
+	agents []*AgentConfig,
+	events *EventLog) (*alerter, error) {
+	if cfg == nil || len(cfg.Rules) == 0 {
+		return nil, nil
+	}
+
+	al := &alerter{
+		rules:  make([]*alertRule, 0, len(cfg.Rules)),
+		queues: make([]*notifyQueue, 0, len(cfg.Notifiers)),
+		agents: agents,
+		events: events,
+		active: map[alertKey]*activeAlert{},
+	}
+	queues := make(map[string]*notifyQueue, len(cfg.Notifiers))
+	names := make([]string, 0, len(cfg.Notifiers))
+	for _, nc := range cfg.Notifiers {
+		if _, found := queues[nc.Name]; found {
+			return nil, errx.Errf(ErrInvalidNotifier,
+				"duplicate notifier '%s'", nc.Name)
+		}
+		notifier, err := NewNotifier(nc)
+		if err != nil {
+			return nil, err
+		}
+		nq := &notifyQueue{
+			name:     nc.Name,
+			notifier: notifier,
+			alerts:   make(chan *Alert, notifyQueueSize),
+		}
+		queues[nc.Name] = nq
+		al.queues = append(al.queues, nq)
+		names = append(names, nc.Name)
+	}
+
+	for _, rule := range cfg.Rules {
+		if rule.Name == "" {
+			return nil, errx.Errf(ErrInvalidAlertRule,
+				"alert rule '%s' does not have a name", rule.Expr)
+		}
+		if slices.ContainsFunc(al.rules, func(ar *alertRule) bool {
+			return ar.Name == rule.Name
+		}) {
+			return nil, errx.Errf(ErrInvalidAlertRule,
+				"duplicate alert rule '%s'", rule.Name)
+		}
+		cond, err := parseAlertExpr(rule.Expr)
+		if err != nil {
+			return nil, err
+		}
+
+		ruleNotifiers := rule.Notifiers
+		if len(ruleNotifiers) == 0 {
+			ruleNotifiers = names
+		}
+		ar := &alertRule{AlertRule: rule, cond: cond}
+		for _, name := range ruleNotifiers {
+			nq, found := queues[name]
+			if !found {
+				return nil, errx.Errf(ErrUnknownNotifier,
+					"unknown notifier '%s' in alert rule '%s'",
+					name, rule.Name)
+			}
+			ar.queues = append(ar.queues, nq)
+		}
+		al.rules = append(al.rules, ar)
+	}
+	return al, nil
+}
+
+func (al *alerter) observe(resp *AgentResponse) {
+	if al == nil {
+		return
+	}
+
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+
+	now := time.Now()
+	node := al.agents[resp.Index].Name
+	for _, rule := range al.rules {
+		if len(rule.Nodes) != 0 && !slices.Contains(rule.Nodes, node) {
+			continue
+		}
+		holds, known, val := rule.cond.eval(resp)
+		if !known {
+			continue
+		}
+
+		key := alertKey{rule: rule.Name, node: resp.Index}
+		aa := al.active[key]
+		if !holds {
+			if aa != nil && aa.alert.State == AlertFiring {
+				aa.alert.State = AlertResolved
+				aa.alert.Time = now
+				al.notify(rule, aa.alert)
+			}
+			delete(al.active, key)
+			continue
+		}
+
+		if aa == nil {
+			aa = &activeAlert{alert: Alert{
+				Rule:  rule.Name,
+				Expr:  rule.Expr,
+				Node:  node,
+				State: AlertPending,
+				Since: now,
+			}}
+			al.active[key] = aa
+		}
+		aa.alert.Time = now
+		if rule.cond.metric >= 0 {
+			aa.alert.Value = &val
+		}
+
+		switch aa.alert.State {
+		case AlertPending:
+			if now.Sub(aa.alert.Since) >= rule.cond.dur {
+				aa.alert.State = AlertFiring
+				aa.notified = now
+				al.notify(rule, aa.alert)
+			}
+		case AlertFiring:
+			repeat := time.Duration(rule.RepeatSecs) * time.Second
+			if repeat > 0 && now.Sub(aa.notified) >= repeat {
+				aa.notified = now
+				al.notify(rule, aa.alert)
+			}
+		}
+	}
+}
+
+// run - delivers the queued notifications until the context is done
+func (al *alerter) run(gtx context.Context) error {
+	if al == nil {
+		return nil
+	}
+	var wg sync.WaitGroup
+	for _, nq := range al.queues {
+		wg.Add(1)
+		go func(nq *notifyQueue) {
+			defer wg.Done()
+			nq.run(gtx)
+		}(nq)
+	}
+	wg.Wait()
+	return nil
+}
+
+// notify - queues the alert for the notifiers of the rule, so that slow
+// notifiers do not hold up the monitor. Alerts are dropped when a notifier
+// falls too far behind
+func (al *alerter) notify(rule *alertRule, alert Alert) {
+	al.events.Add(EventAlert, alert.Node, alert.Summary())
+	for _, nq := range rule.queues {
+		select {
+		case nq.alerts <- &alert:
+		default:
+			log.Warn().
+				Str("notifier", nq.name).
+				Str("rule", alert.Rule).
+				Str("node", alert.Node).
+				Msg("notification queue is full, dropping alert")
+		}
+	}
+}
+
+// list - alerts that are either pending or firing
+func (al *alerter) list() []*Alert {
+	if al == nil {
+		return []*Alert{}
+	}
+
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+
+	alerts := make([]*Alert, 0, len(al.active))
+	for _, aa := range al.active {
+		alert := aa.alert
+		alerts = append(alerts, &alert)
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].Rule != alerts[j].Rule {
+			return alerts[i].Rule < alerts[j].Rule
+		}
+		return alerts[i].Node < alerts[j].Node
+	})
+	return alerts
+}
+
+func getAlertEndpoints(al *alerter) []*httpx.Endpoint {
+	return []*httpx.Endpoint{
+		{
+			Method:   echo.GET,
+			Path:     "/alerts",
+			Category: "alerts",
+			Desc:     "Get the alerts that are pending or firing",
+			Version:  "v1",
+			Handler: func(etx echo.Context) error {
+				return httpx.SendJSON(etx, al.list())
+			},
+		},
+	}
+}
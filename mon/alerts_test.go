@@ -0,0 +1,275 @@
+package mon
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseAlertExpr(t *testing.T) {
+	tests := []struct {
+		expr      string
+		metric    string
+		op        string
+		threshold float64
+		dur       time.Duration
+		fails     bool
+	}{
+		{expr: "cpuTemp > 75", metric: "cpuTemp", op: ">", threshold: 75},
+		{
+			expr:      "memUsage >= 90.5 for 2m",
+			metric:    "memUsage",
+			op:        ">=",
+			threshold: 90.5,
+			dur:       2 * time.Minute,
+		},
+		{expr: "unreachable"},
+		{expr: "agent unreachable for 30s", dur: 30 * time.Second},
+		{expr: "", fails: true},
+		{expr: "cpuTemp >", fails: true},
+		{expr: "fanSpeed > 10", fails: true},
+		{expr: "cpuTemp => 10", fails: true},
+		{expr: "cpuTemp > hot", fails: true},
+		{expr: "cpuTemp > 75 for ever", fails: true},
+		{expr: "unreachable for -1m", fails: true},
+	}
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			cond, err := parseAlertExpr(test.expr)
+			if test.fails {
+				if !errors.Is(err, ErrInvalidAlertRule) {
+					t.Fatalf("expected ErrInvalidAlertRule, got: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			metric := ""
+			if cond.metric >= 0 {
+				metric = historyMetrics[cond.metric]
+			}
+			if metric != test.metric || cond.op != test.op ||
+				cond.threshold != test.threshold || cond.dur != test.dur {
+				t.Errorf("unexpected condition %+v", *cond)
+			}
+		})
+	}
+}
+
+func newTestAlerter(t *testing.T, rules ...*AlertRule) *alerter {
+	t.Helper()
+	al, err := newAlerter(&AlertConfig{
+		Rules: rules,
+		Notifiers: []*NotifierConfig{
+			{Name: "out", Type: NotifyStdout},
+		},
+	}, []*AgentConfig{{Name: "pi1"}, {Name: "pi2"}}, NewEventLog(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return al
+}
+
+// notified - the alerts queued for notification since the last call
+func notified(al *alerter) []*Alert {
+	alerts := []*Alert{}
+	for {
+		select {
+		case alert := <-al.queues[0].alerts:
+			alerts = append(alerts, alert)
+		default:
+			return alerts
+		}
+	}
+}
+
+func tempResponse(idx int, temp float64) *AgentResponse {
+	milli := temp * 1000
+	return &AgentResponse{
+		Index: idx,
+		Data:  &SysInfo{CPUTemp: &milli},
+		Time:  time.Now(),
+	}
+}
+
+func expectStates(t *testing.T, al *alerter, states ...AlertState) {
+	t.Helper()
+	alerts := notified(al)
+	if len(alerts) != len(states) {
+		t.Fatalf("expected %d notifications, got %d: %v",
+			len(states), len(alerts), alerts)
+	}
+	for idx, alert := range alerts {
+		if alert.State != states[idx] {
+			t.Errorf("expected notification %d to be %s, got %s",
+				idx, states[idx], alert.State)
+		}
+	}
+}
+
+func expectActive(t *testing.T, al *alerter, states ...AlertState) {
+	t.Helper()
+	active := al.list()
+	if len(active) != len(states) {
+		t.Fatalf("expected %d active alerts, got %d", len(states), len(active))
+	}
+	for idx, alert := range active {
+		if alert.State != states[idx] {
+			t.Errorf("expected active alert %d to be %s, got %s",
+				idx, states[idx], alert.State)
+		}
+	}
+}
+
+func TestAlertPendingFiringResolved(t *testing.T) {
+	al := newTestAlerter(t, &AlertRule{
+		Name: "hot",
+		Expr: "cpuTemp > 75 for 50ms",
+	})
+
+	// Condition does not hold, nothing happens
+	al.observe(tempResponse(0, 60))
+	expectActive(t, al)
+	expectStates(t, al)
+
+	// Holds, but not long enough, so the alert is only pending
+	al.observe(tempResponse(0, 80))
+	expectActive(t, al, AlertPending)
+	expectStates(t, al)
+
+	// Held long enough, fires once
+	time.Sleep(60 * time.Millisecond)
+	al.observe(tempResponse(0, 81))
+	expectActive(t, al, AlertFiring)
+	expectStates(t, al, AlertFiring)
+
+	al.observe(tempResponse(0, 82))
+	expectStates(t, al)
+
+	// Resolves once and is not active any more
+	al.observe(tempResponse(0, 70))
+	expectActive(t, al)
+	expectStates(t, al, AlertResolved)
+
+	al.observe(tempResponse(0, 70))
+	expectStates(t, al)
+}
+
+func TestAlertPendingNotResolved(t *testing.T) {
+	al := newTestAlerter(t, &AlertRule{
+		Name: "hot",
+		Expr: "cpuTemp > 75 for 1h",
+	})
+
+	// Pending alerts that go away were never notified, so are not resolved
+	al.observe(tempResponse(0, 80))
+	expectActive(t, al, AlertPending)
+	al.observe(tempResponse(0, 70))
+	expectActive(t, al)
+	expectStates(t, al)
+}
+
+func TestAlertUnreachable(t *testing.T) {
+	al := newTestAlerter(t, &AlertRule{
+		Name:  "down",
+		Expr:  "unreachable",
+		Nodes: []string{"pi2"},
+	})
+
+	down := func(idx int) *AgentResponse {
+		return &AgentResponse{
+			Index: idx,
+			Err:   errors.New("connection refused"),
+			Time:  time.Now(),
+		}
+	}
+
+	// Rule is limited to pi2
+	al.observe(down(0))
+	expectActive(t, al)
+	expectStates(t, al)
+
+	al.observe(down(1))
+	expectActive(t, al, AlertFiring)
+	expectStates(t, al, AlertFiring)
+
+	// Metric rules ignore failed polls, unreachable ones resolve on success
+	al.observe(tempResponse(1, 50))
+	expectActive(t, al)
+	expectStates(t, al, AlertResolved)
+}
+
+func TestAlertUnknownMetricKeepsState(t *testing.T) {
+	al := newTestAlerter(t, &AlertRule{Name: "hot", Expr: "cpuTemp > 75"})
+
+	al.observe(tempResponse(0, 80))
+	expectStates(t, al, AlertFiring)
+
+	// A failed poll says nothing about the temperature
+	al.observe(&AgentResponse{
+		Index: 0,
+		Err:   errors.New("timeout"),
+		Time:  time.Now(),
+	})
+	expectActive(t, al, AlertFiring)
+	expectStates(t, al)
+}
+
+func TestAlertRepeat(t *testing.T) {
+	al := newTestAlerter(t, &AlertRule{
+		Name:       "hot",
+		Expr:       "cpuTemp > 75",
+		RepeatSecs: 1,
+	})
+
+	al.observe(tempResponse(0, 80))
+	expectStates(t, al, AlertFiring)
+	al.observe(tempResponse(0, 80))
+	expectStates(t, al)
+
+	// Pretend the notification went out a while ago
+	al.active[alertKey{rule: "hot", node: 0}].notified =
+		time.Now().Add(-2 * time.Second)
+	al.observe(tempResponse(0, 80))
+	expectStates(t, al, AlertFiring)
+}
+
+func TestNewAlerterValidation(t *testing.T) {
+	agents := []*AgentConfig{{Name: "pi1"}}
+	tests := []struct {
+		name string
+		cfg  *AlertConfig
+		err  error
+	}{
+		{"unnamed rule", &AlertConfig{
+			Rules: []*AlertRule{{Expr: "unreachable"}},
+		}, ErrInvalidAlertRule},
+		{"duplicate rule", &AlertConfig{
+			Rules: []*AlertRule{
+				{Name: "a", Expr: "unreachable"},
+				{Name: "a", Expr: "cpuTemp > 1"},
+			},
+		}, ErrInvalidAlertRule},
+		{"unknown notifier", &AlertConfig{
+			Rules: []*AlertRule{
+				{Name: "a", Expr: "unreachable", Notifiers: []string{"x"}},
+			},
+		}, ErrUnknownNotifier},
+		{"duplicate notifier", &AlertConfig{
+			Rules: []*AlertRule{{Name: "a", Expr: "unreachable"}},
+			Notifiers: []*NotifierConfig{
+				{Name: "x", Type: NotifyStdout},
+				{Name: "x", Type: NotifyStdout},
+			},
+		}, ErrInvalidNotifier},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := newAlerter(test.cfg, agents, NewEventLog(""))
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected %v, got: %v", test.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package mon
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/labstack/echo/v4"
+	"github.com/varunamachi/libx/auth"
+	"github.com/varunamachi/libx/errx"
+	"github.com/varunamachi/libx/httpx"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("mon.auth.invalidCredentials")
+)
+
+// Token type put in the JWTs issued by monitor and agents. Any type other
+// than "user" makes libx skip its user database lookup
+const monitorTokenType = "monitor"
+
+// User - a user allowed to use the mutating APIs of monitor or agent. Either a
+// plain password or a bcrypt hash of it can be given
+type User struct {
+	UserId       string `json:"userId"`
+	Password     string `json:"password,omitempty"`
+	PasswordHash string `json:"passwordHash,omitempty"`
+}
+
+func (u *User) verify(password string) bool {
+	if u.PasswordHash != "" {
+		return bcrypt.CompareHashAndPassword(
+			[]byte(u.PasswordHash), []byte(password)) == nil
+	}
+	return u.Password != "" && subtle.ConstantTimeCompare(
+		[]byte(u.Password), []byte(password)) == 1
+}
+
+func authenticate(users []*User, authData httpx.AuthData) (string, error) {
+	userId, _ := authData["userId"].(string)
+	password, _ := authData["password"].(string)
+	for _, user := range users {
+		if user.UserId == userId && user.verify(password) {
+			return userId, nil
+		}
+	}
+	return "", errx.Errf(ErrInvalidCredentials,
+		"invalid credentials given for user '%s'", userId)
+}
+
+func issueToken(userId string) (string, error) {
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["userId"] = userId
+	claims["exp"] = time.Now().Add(auth.UserSessionTimeout).Unix()
+	claims["type"] = monitorTokenType
+
+	signed, err := token.SignedString(auth.GetJWTKey())
+	if err != nil {
+		return "", errx.Errf(err, "failed to generate session token")
+	}
+	return signed, nil
+}
+
+// secure - makes the endpoints that change state require a token. Without
+// users they are refused, unless insecure is set to leave them open. Read
+// only endpoints are left open for the dashboards
+func secure(
+	users []*User, insecure bool, eps ...*httpx.Endpoint) []*httpx.Endpoint {
+	for _, ep := range eps {
+		if ep.Method == echo.GET {
+			continue
+		}
+		if len(users) != 0 {
+			ep.Role = auth.Normal
+		} else if !insecure {
+			ep.Handler = forbidden
+		}
+	}
+	return eps
+}
+
+// forbidden - handler for the endpoints that change state when there are no
+// users to authenticate against
+func forbidden(etx echo.Context) error {
+	return &echo.HTTPError{
+		Code:    http.StatusForbidden,
+		Message: "no users are configured, changes are not allowed",
+	}
+}
+
+// secureAll - like secure, but read only endpoints require a token as well,
+// for the ones that expose sensitive data such as logs
+func secureAll(users []*User, eps ...*httpx.Endpoint) []*httpx.Endpoint {
+	if len(users) == 0 {
+		return eps
+	}
+	for _, ep := range eps {
+		ep.Role = auth.Normal
+	}
+	return eps
+}
+
+func getAuthEndpoints(users []*User) []*httpx.Endpoint {
+	return []*httpx.Endpoint{
+		{
+			Method:   echo.POST,
+			Path:     "/auth/user",
+			Category: "auth",
+			Desc:     "Login and get a session token",
+			Version:  "v1",
+			Handler: func(etx echo.Context) error {
+				authData := httpx.AuthData{}
+				if err := etx.Bind(&authData); err != nil {
+					return &echo.HTTPError{
+						Message:  "invalid auth data",
+						Code:     http.StatusBadRequest,
+						Internal: err,
+					}
+				}
+
+				userId, err := authenticate(users, authData)
+				if err != nil {
+					return &echo.HTTPError{
+						Message:  "invalid credentials",
+						Code:     http.StatusUnauthorized,
+						Internal: err,
+					}
+				}
+
+				token, err := issueToken(userId)
+				if err != nil {
+					return &echo.HTTPError{
+						Message:  "failed to create session",
+						Code:     http.StatusInternalServerError,
+						Internal: err,
+					}
+				}
+				return httpx.SendJSON(etx, &loginResult{Token: token})
+			},
+		},
+	}
+}
@@ -25,13 +25,24 @@ func Login(
 		return nil
 	}
 
+	token, err := requestToken(gtx, client, authData)
+	if err != nil {
+		return err
+	}
+	client.SetToken(token)
+	return nil
+}
+
+func requestToken(
+	gtx context.Context,
+	client *httpx.Client,
+	authData httpx.AuthData) (string, error) {
 	rr := client.Post(gtx, authData, "/api/v1/auth/user")
 	lres := &loginResult{}
 	if err := rr.LoadClose(&lres); err != nil {
-		return errx.Wrap(err)
+		return "", errx.Wrap(err)
 	}
-	client.SetToken(lres.Token)
-	return nil
+	return lres.Token, nil
 }
 
 func CreateClient(ctx *cli.Context) (
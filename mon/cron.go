@@ -0,0 +1,175 @@
+package mon
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/varunamachi/libx/errx"
+)
+
+var (
+	ErrInvalidCron = errors.New("mon.cron.invalid")
+)
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+type cronField struct {
+	min, max int
+}
+
+var cronFields = []cronField{
+	{0, 59}, // minute
+	{0, 23}, // hour
+	{1, 31}, // day of month
+	{1, 12}, // month
+	{0, 7},  // day of week, both 0 and 7 are sunday
+}
+
+// cronExpr - parsed form of a standard five field cron expression. Each field
+// is a bit set of the values it matches
+type cronExpr struct {
+	minute, hour, dom, month, dow uint64
+
+	// Like cron, if both day of month and day of week are restricted, a day
+	// matching either of them matches
+	domStar, dowStar bool
+}
+
+// parseCron - parses expressions of the form 'minute hour dom month dow'
+// where each field can be '*', a number, a range 'a-b', a list 'a,b' and
+// any of these with a step '/n'. Macros such as @daily are also accepted
+func parseCron(expr string) (*cronExpr, error) {
+	expr = strings.TrimSpace(expr)
+	if macro, found := cronMacros[expr]; found {
+		expr = macro
+	}
+
+	parts := strings.Fields(expr)
+	if len(parts) != len(cronFields) {
+		return nil, errx.Errf(ErrInvalidCron,
+			"cron expression '%s' should have 5 fields", expr)
+	}
+
+	bits := make([]uint64, len(parts))
+	for idx, part := range parts {
+		var err error
+		bits[idx], err = parseCronField(part, cronFields[idx])
+		if err != nil {
+			return nil, errx.Errf(err,
+				"invalid field '%s' in cron expression '%s'", part, expr)
+		}
+	}
+
+	ce := &cronExpr{
+		minute:  bits[0],
+		hour:    bits[1],
+		dom:     bits[2],
+		month:   bits[3],
+		dow:     bits[4],
+		domStar: strings.HasPrefix(parts[2], "*"),
+		dowStar: strings.HasPrefix(parts[4], "*"),
+	}
+	if ce.dow&(1<<7) != 0 {
+		ce.dow |= 1
+	}
+	return ce, nil
+}
+
+func parseCronField(field string, limits cronField) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepStr)
+			if err != nil || step <= 0 {
+				return 0, errx.Errf(ErrInvalidCron, "invalid step '%s'", item)
+			}
+		}
+
+		start, end := limits.min, limits.max
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			lo, hi, _ := strings.Cut(rng, "-")
+			var err1, err2 error
+			start, err1 = strconv.Atoi(lo)
+			end, err2 = strconv.Atoi(hi)
+			if err1 != nil || err2 != nil {
+				return 0, errx.Errf(ErrInvalidCron, "invalid range '%s'", rng)
+			}
+		default:
+			val, err := strconv.Atoi(rng)
+			if err != nil {
+				return 0, errx.Errf(ErrInvalidCron, "invalid value '%s'", rng)
+			}
+			start = val
+			if !hasStep {
+				end = val
+			}
+		}
+
+		if start < limits.min || end > limits.max || start > end {
+			return 0, errx.Errf(ErrInvalidCron,
+				"'%s' is outside %d-%d", item, limits.min, limits.max)
+		}
+		for val := start; val <= end; val += step {
+			bits |= 1 << val
+		}
+	}
+	return bits, nil
+}
+
+func (ce *cronExpr) matchesDay(t time.Time) bool {
+	domMatch := ce.dom&(1<<t.Day()) != 0
+	dowMatch := ce.dow&(1<<int(t.Weekday())) != 0
+	if ce.domStar || ce.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// matches - tells if the expression matches the minute of given time
+func (ce *cronExpr) matches(t time.Time) bool {
+	return ce.minute&(1<<t.Minute()) != 0 &&
+		ce.hour&(1<<t.Hour()) != 0 &&
+		ce.month&(1<<int(t.Month())) != 0 &&
+		ce.matchesDay(t)
+}
+
+// next - gives the first time after the given time matching the expression.
+// Zero time is returned if there is no match within next five years, which
+// happens for expressions like '0 0 30 2 *'
+func (ce *cronExpr) next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if ce.month&(1<<int(t.Month())) == 0 || !ce.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0,
+				t.Location())
+			continue
+		}
+		if ce.hour&(1<<t.Hour()) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0,
+				t.Location())
+			continue
+		}
+		if ce.minute&(1<<t.Minute()) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
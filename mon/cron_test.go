@@ -0,0 +1,194 @@
+package mon
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func cronBits(lo, hi, step int) uint64 {
+	var bits uint64
+	for val := lo; val <= hi; val += step {
+		bits |= 1 << val
+	}
+	return bits
+}
+
+func cronVals(vals ...int) uint64 {
+	var bits uint64
+	for _, val := range vals {
+		bits |= 1 << val
+	}
+	return bits
+}
+
+func TestParseCronField(t *testing.T) {
+	minute, dom := cronFields[0], cronFields[2]
+	tests := []struct {
+		field  string
+		limits cronField
+		want   uint64
+		fails  bool
+	}{
+		{field: "*", limits: minute, want: cronBits(0, 59, 1)},
+		{field: "*", limits: dom, want: cronBits(1, 31, 1)},
+		{field: "5", limits: minute, want: cronVals(5)},
+		{field: "1-5", limits: minute, want: cronBits(1, 5, 1)},
+		{field: "*/15", limits: minute, want: cronVals(0, 15, 30, 45)},
+		{field: "*/10", limits: dom, want: cronVals(1, 11, 21, 31)},
+		{field: "5/15", limits: minute, want: cronVals(5, 20, 35, 50)},
+		{field: "10-30/10", limits: minute, want: cronVals(10, 20, 30)},
+		{field: "1,3,5", limits: minute, want: cronVals(1, 3, 5)},
+		{field: "1-3,10-14/2", limits: minute,
+			want: cronVals(1, 2, 3, 10, 12, 14)},
+		{field: "59", limits: minute, want: cronVals(59)},
+		{field: "60", limits: minute, fails: true},
+		{field: "0", limits: dom, fails: true},
+		{field: "5-1", limits: minute, fails: true},
+		{field: "1-", limits: minute, fails: true},
+		{field: "-1", limits: minute, fails: true},
+		{field: "a", limits: minute, fails: true},
+		{field: "", limits: minute, fails: true},
+		{field: "1,,2", limits: minute, fails: true},
+		{field: "*/0", limits: minute, fails: true},
+		{field: "*/x", limits: minute, fails: true},
+	}
+	for _, test := range tests {
+		t.Run(test.field, func(t *testing.T) {
+			bits, err := parseCronField(test.field, test.limits)
+			if test.fails {
+				if !errors.Is(err, ErrInvalidCron) {
+					t.Fatalf("expected ErrInvalidCron, got: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bits != test.want {
+				t.Errorf("expected %b, got %b", test.want, bits)
+			}
+		})
+	}
+}
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expr  string
+		fails bool
+	}{
+		{expr: "* * * * *"},
+		{expr: "  0 0 * * *  "},
+		{expr: "@daily"},
+		{expr: "@hourly"},
+		{expr: "0 0 29 2 *"},
+		{expr: "", fails: true},
+		{expr: "* * * *", fails: true},
+		{expr: "* * * * * *", fails: true},
+		{expr: "@every", fails: true},
+		{expr: "0 24 * * *", fails: true},
+		{expr: "0 0 0 * *", fails: true},
+		{expr: "0 0 * 13 *", fails: true},
+		{expr: "0 0 * * 8", fails: true},
+	}
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			_, err := parseCron(test.expr)
+			if test.fails && !errors.Is(err, ErrInvalidCron) {
+				t.Fatalf("expected ErrInvalidCron, got: %v", err)
+			}
+			if !test.fails && err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func cronTime(val string) time.Time {
+	tm, err := time.Parse("2006-01-02 15:04", val)
+	if err != nil {
+		panic(err)
+	}
+	return tm
+}
+
+func TestCronMatches(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		time string
+		want bool
+	}{
+		{"weekday morning", "30 8 * * 1-5", "2026-10-19 08:30", true},
+		{"weekday on saturday", "30 8 * * 1-5", "2026-10-17 08:30", false},
+		{"wrong minute", "30 8 * * 1-5", "2026-10-19 08:31", false},
+		{"wrong month", "0 0 * 1-6 *", "2026-10-19 00:00", false},
+		{"macro", "@daily", "2026-10-19 00:00", true},
+
+		// Both day fields restricted, either of them matching is enough
+		{"dom or dow on dom", "0 0 13 * 5", "2026-10-13 00:00", true},
+		{"dom or dow on dow", "0 0 13 * 5", "2026-10-16 00:00", true},
+		{"dom or dow on neither", "0 0 13 * 5", "2026-10-14 00:00", false},
+		{"full dom range or dow", "0 0 1-31 * 5", "2026-10-14 00:00", true},
+
+		// Only one of the day fields restricted, the other does not matter
+		{"dom only", "0 0 13 * *", "2026-10-16 00:00", false},
+		{"dow only", "0 0 * * 5", "2026-10-13 00:00", false},
+
+		// A day field starting with '*' counts as unrestricted even with a
+		// step, so both have to match
+		{"dom step and dow", "0 0 */2 * 5", "2026-10-23 00:00", true},
+		{"dom step not dow", "0 0 */2 * 5", "2026-10-13 00:00", false},
+		{"dow not dom step", "0 0 */2 * 5", "2026-10-16 00:00", false},
+
+		{"sunday as 0", "0 0 * * 0", "2026-11-01 00:00", true},
+		{"sunday as 7", "0 0 * * 7", "2026-11-01 00:00", true},
+		{"sunday in range", "0 0 * * 5-7", "2026-11-01 00:00", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ce, err := parseCron(test.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ce.matches(cronTime(test.time)); got != test.want {
+				t.Errorf("'%s' at %s: expected %v, got %v",
+					test.expr, test.time, test.want, got)
+			}
+		})
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	tests := []struct {
+		expr  string
+		after string
+		want  string
+	}{
+		{"30 8 * * 1-5", "2026-10-16 09:00", "2026-10-19 08:30"},
+		{"0 0 13 * 5", "2026-10-13 00:00", "2026-10-16 00:00"},
+		{"*/15 * * * *", "2026-10-19 10:07", "2026-10-19 10:15"},
+		{"0 0 * * *", "2026-10-19 00:00", "2026-10-20 00:00"},
+		{"0 0 1 * *", "2026-12-15 00:00", "2027-01-01 00:00"},
+		{"0 12 29 2 *", "2026-10-19 00:00", "2028-02-29 12:00"},
+		{"0 0 30 2 *", "2026-10-19 00:00", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			ce, err := parseCron(test.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			next := ce.next(cronTime(test.after).Add(30 * time.Second))
+			if test.want == "" {
+				if !next.IsZero() {
+					t.Errorf("expected no next run, got %s", next)
+				}
+				return
+			}
+			if want := cronTime(test.want); !next.Equal(want) {
+				t.Errorf("expected next run at %s, got %s", want, next)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package mon
+
+import (
+	"embed"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/varunamachi/libx/httpx"
+)
+
+// dashboardFiles - the web dashboard, a single page that only talks to the
+// monitor APIs. Live updates come from the stream endpoint
+//
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// getDashboardPages - the dashboard is served from /dashboard/, the root
+// redirects there
+func getDashboardPages() []*httpx.Endpoint {
+	// Paths of the embedded files match the URL paths
+	fileServer := http.FileServer(http.FS(dashboardFiles))
+	redirect := func(etx echo.Context) error {
+		return etx.Redirect(http.StatusFound, "/dashboard/")
+	}
+
+	return []*httpx.Endpoint{
+		{
+			Method:   echo.GET,
+			Path:     "/",
+			Category: "dashboard",
+			Desc:     "Redirect to the dashboard",
+			Handler:  redirect,
+		},
+		{
+			Method:   echo.GET,
+			Path:     "/dashboard",
+			Category: "dashboard",
+			Desc:     "Redirect to the dashboard",
+			Handler:  redirect,
+		},
+		{
+			Method:   echo.GET,
+			Path:     "/dashboard/*",
+			Category: "dashboard",
+			Desc:     "Web dashboard of the cluster",
+			Handler:  echo.WrapHandler(fileServer),
+		},
+	}
+}
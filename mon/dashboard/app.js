@@ -0,0 +1,546 @@
+'use strict';
+
+// Same thresholds as the terminal UI
+const thresholds = {
+    temp: [70, 80],
+    cpu: [75, 90],
+    mem: [75, 90],
+    swap: [50, 80],
+    load: [4, 8],
+    disk: [80, 95],
+};
+
+const metricUnits = {
+    cpuTemp: '°C',
+    cpuUsage: '%',
+    memUsage: '%',
+    swapUsage: '%',
+    load1: '',
+    diskUsage: '%',
+    netRx: 'B/s',
+    netTx: 'B/s',
+};
+
+const state = {
+    nodes: new Map(),
+    relays: [],
+    token: sessionStorage.getItem('picl.token'),
+    user: sessionStorage.getItem('picl.user'),
+    history: null,
+};
+
+const $ = (id) => document.getElementById(id);
+
+class AuthError extends Error {}
+
+async function api(method, path, body) {
+    const headers = {};
+    if (body !== undefined) {
+        headers['Content-Type'] = 'application/json';
+    }
+    if (state.token) {
+        headers['Authorization'] = 'Bearer ' + state.token;
+    }
+    const res = await fetch('/api/v1' + path, {
+        method: method,
+        headers: headers,
+        body: body === undefined ? undefined : JSON.stringify(body),
+    });
+    if (res.status === 401 || res.status === 403) {
+        throw new AuthError('login required');
+    }
+    if (!res.ok) {
+        let msg = res.statusText;
+        try {
+            msg = (await res.json()).msg || msg;
+        } catch (e) {
+            // Not a JSON error, keep the status text
+        }
+        throw new Error(msg);
+    }
+    const type = res.headers.get('Content-Type') || '';
+    return type.includes('json') ? res.json() : res.text();
+}
+
+// ---- Formatting ----
+
+function level(val, limits) {
+    if (val === undefined || val === null || isNaN(val)) {
+        return '';
+    }
+    if (val >= limits[1]) {
+        return 'crit';
+    }
+    return val >= limits[0] ? 'warn' : '';
+}
+
+function humanRate(rate) {
+    const units = ['B', 'K', 'M', 'G'];
+    let idx = 0;
+    while (rate >= 1024 && idx < units.length - 1) {
+        rate /= 1024;
+        idx++;
+    }
+    return rate.toFixed(1) + units[idx];
+}
+
+function pct(val) {
+    return val.toFixed(1) + '%';
+}
+
+function ago(time) {
+    const secs = Math.round((Date.now() - new Date(time).getTime()) / 1000);
+    if (secs < 60) {
+        return secs + 's ago';
+    }
+    if (secs < 3600) {
+        return Math.floor(secs / 60) + 'm ago';
+    }
+    return Math.floor(secs / 3600) + 'h ago';
+}
+
+function throttleFlags(th) {
+    if (!th) {
+        return '';
+    }
+    const flags = [];
+    if (th.underVoltage) flags.push('UV');
+    if (th.freqCapped) flags.push('CAP');
+    if (th.throttled) flags.push('THR');
+    if (th.softTempLimit) flags.push('TMP');
+    if (flags.length === 0 && th.raw !== 0) flags.push('past');
+    return flags.join(',');
+}
+
+function el(tag, attrs, ...children) {
+    const elem = document.createElement(tag);
+    for (const [key, val] of Object.entries(attrs || {})) {
+        if (key.startsWith('on')) {
+            elem.addEventListener(key.substring(2), val);
+        } else {
+            elem.setAttribute(key, val);
+        }
+    }
+    for (const child of children) {
+        if (child !== null && child !== undefined) {
+            elem.append(child);
+        }
+    }
+    return elem;
+}
+
+// ---- Node cards ----
+
+// metricRow - value with its level, percentages also get a bar
+function metricRow(label, text, cls, barPct) {
+    const row = el('div', {class: 'row'},
+        el('span', {class: 'label'}, label),
+        el('span', {class: cls}, text));
+    if (barPct === undefined) {
+        return row;
+    }
+    const width = Math.max(0, Math.min(100, barPct));
+    return el('div', {}, row, el('div', {class: 'bar'},
+        el('div', {class: cls, style: 'width:' + width + '%'})));
+}
+
+function pctRow(label, val, limits) {
+    return metricRow(label, pct(val), level(val, limits), val);
+}
+
+function relayOf(node) {
+    return state.relays.find((rl) => rl.host === node);
+}
+
+function renderCard(update) {
+    const info = update.data;
+    const card = el('div', {class: 'card' + (update.up ? '' : ' down')});
+    card.append(el('h3', {},
+        el('span', {}, update.node),
+        el('span', {class: update.up ? '' : 'crit'},
+            update.up ? 'up' : 'down')));
+
+    if (update.error) {
+        card.append(el('div', {class: 'error'}, update.error));
+    }
+    if (info) {
+        if (info.cpuTemp !== undefined) {
+            const temp = info.cpuTemp / 1000;
+            card.append(metricRow('Temp', temp.toFixed(1) + '°C',
+                level(temp, thresholds.temp)));
+        }
+        card.append(pctRow('CPU', info.cpuUsage, thresholds.cpu));
+        card.append(pctRow('Memory', info.memUsage, thresholds.mem));
+        if (info.version >= 1) {
+            card.append(pctRow('Swap', info.swapUsage, thresholds.swap));
+            const disk = Math.max(0, ...(info.disks || [])
+                .map((du) => du.usedPct));
+            card.append(pctRow('Disk', disk, thresholds.disk));
+            if (info.load) {
+                const ld = info.load;
+                card.append(metricRow('Load',
+                    [ld.load1, ld.load5, ld.load15]
+                        .map((val) => val.toFixed(2)).join(' '),
+                    level(ld.load1, thresholds.load)));
+            }
+            let rx = 0;
+            let tx = 0;
+            for (const nr of info.net || []) {
+                rx += nr.rxRate;
+                tx += nr.txRate;
+            }
+            card.append(metricRow('Net',
+                humanRate(rx) + '/s ↓  ' + humanRate(tx) + '/s ↑', ''));
+            const flags = throttleFlags(info.throttling);
+            if (flags) {
+                card.append(metricRow('Throttling', flags, 'crit'));
+            }
+        }
+    }
+
+    const foot = el('div', {class: 'foot'},
+        el('span', {}, update.time && !update.time.startsWith('0001') ?
+            ago(update.time) : 'no data yet'));
+    const relay = relayOf(update.node);
+    if (relay) {
+        foot.append(el('button', {
+            type: 'button',
+            onclick: () => switchRelay(relay, !relay.on),
+        }, relay.on ? 'Power off' : 'Power on'));
+    }
+    card.append(foot);
+    return card;
+}
+
+function renderNodes() {
+    const nodes = [...state.nodes.values()].sort((a, b) => a.index - b.index);
+    $('nodes').replaceChildren(...nodes.map(renderCard));
+
+    const select = $('hist-node');
+    if (select.options.length !== nodes.length) {
+        const current = select.value;
+        select.replaceChildren(...nodes.map((nu) =>
+            el('option', {value: nu.node}, nu.node)));
+        if (current) {
+            select.value = current;
+        }
+        loadHistory();
+    }
+}
+
+let renderPending = false;
+
+function scheduleRender() {
+    if (!renderPending) {
+        renderPending = true;
+        requestAnimationFrame(() => {
+            renderPending = false;
+            renderNodes();
+        });
+    }
+}
+
+function connect() {
+    const source = new EventSource('/api/v1/stream?events=sample,relay');
+    source.addEventListener('open', () => {
+        $('conn').className = 'conn up';
+        $('conn').textContent = 'live';
+    });
+    source.addEventListener('error', () => {
+        // EventSource reconnects by itself
+        $('conn').className = 'conn down';
+        $('conn').textContent = 'disconnected';
+    });
+    source.addEventListener('sample', (evt) => {
+        const update = JSON.parse(evt.data);
+        state.nodes.set(update.index, update);
+        scheduleRender();
+    });
+    source.addEventListener('relay', (evt) => {
+        const change = JSON.parse(evt.data);
+        const relay = state.relays.find((rl) => rl.slot === change.slot);
+        if (relay) {
+            relay.on = change.on;
+            renderRelays();
+            scheduleRender();
+        }
+    });
+}
+
+// ---- Relays ----
+
+function confirmAction(text) {
+    return new Promise((resolve) => {
+        const dialog = $('confirm');
+        $('confirm-text').textContent = text;
+        dialog.addEventListener('close', () => {
+            resolve(dialog.returnValue === 'ok');
+        }, {once: true});
+        dialog.returnValue = '';
+        dialog.showModal();
+    });
+}
+
+async function withLogin(action) {
+    try {
+        return await action();
+    } catch (err) {
+        if (!(err instanceof AuthError) || !(await login())) {
+            throw err;
+        }
+        return action();
+    }
+}
+
+async function switchRelay(relay, on) {
+    const target = relay.host ? ` (node ${relay.host})` : '';
+    const ok = await confirmAction(
+        `Switch relay ${relay.relay}${target} ${on ? 'on' : 'off'}?`);
+    if (!ok) {
+        return;
+    }
+    try {
+        await withLogin(() => api('POST',
+            `/switch/${relay.slot}/${on ? 'on' : 'off'}`));
+        $('relay-msg').textContent = '';
+    } catch (err) {
+        $('relay-msg').textContent = 'Failed to switch relay: ' + err.message;
+    }
+    loadRelays();
+}
+
+function renderRelays() {
+    const rows = state.relays.map((rl) => el('tr', {},
+        el('td', {}, String(rl.slot)),
+        el('td', {}, rl.relay),
+        el('td', {}, rl.host || '-'),
+        el('td', {class: rl.on ? '' : 'warn'}, rl.on ? 'on' : 'off'),
+        el('td', {}, el('button', {
+            type: 'button',
+            onclick: () => switchRelay(rl, !rl.on),
+        }, rl.on ? 'Turn off' : 'Turn on'))));
+    $('relays').tBodies[0].replaceChildren(...rows);
+}
+
+async function loadRelays() {
+    try {
+        state.relays = await api('GET', '/power');
+        if ($('relay-msg').textContent.startsWith('Relays')) {
+            $('relay-msg').textContent = '';
+        }
+    } catch (err) {
+        state.relays = [];
+        $('relay-msg').textContent = 'Relays are not available: ' +
+            err.message;
+    }
+    renderRelays();
+    renderNodes();
+}
+
+// ---- Alerts ----
+
+async function loadAlerts() {
+    let alerts;
+    try {
+        alerts = await api('GET', '/alerts');
+    } catch (err) {
+        $('alert-msg').textContent = 'Failed to get alerts: ' + err.message;
+        return;
+    }
+    const rows = alerts.map((al) => el('tr', {},
+        el('td', {class: al.state === 'firing' ? 'crit' : 'warn'}, al.state),
+        el('td', {}, al.rule),
+        el('td', {}, al.node),
+        el('td', {}, al.expr),
+        el('td', {}, al.value === undefined ? '-' : al.value.toFixed(2)),
+        el('td', {}, ago(al.since))));
+    $('alerts').tBodies[0].replaceChildren(...rows);
+    $('alert-msg').textContent = alerts.length ? '' : 'No active alerts';
+}
+
+// ---- History ----
+
+async function loadHistory() {
+    const node = $('hist-node').value;
+    const metric = $('hist-metric').value;
+    if (!node) {
+        return;
+    }
+    const from = Math.floor(Date.now() / 1000) - Number($('hist-range').value);
+    const query = new URLSearchParams({node: node, metric: metric, from: from});
+    try {
+        state.history = await api('GET', '/history?' + query);
+        $('hist-msg').textContent = state.history.points.length ?
+            '' : 'No history for the selected range';
+    } catch (err) {
+        state.history = null;
+        $('hist-msg').textContent = 'Failed to get history: ' + err.message;
+    }
+    drawChart();
+}
+
+function formatValue(metric, val) {
+    if (metric === 'netRx' || metric === 'netTx') {
+        return humanRate(val) + '/s';
+    }
+    return val.toFixed(metric === 'load1' ? 2 : 1) + metricUnits[metric];
+}
+
+function drawChart() {
+    const canvas = $('chart');
+    const ratio = window.devicePixelRatio || 1;
+    const width = canvas.clientWidth;
+    const height = canvas.clientHeight;
+    canvas.width = width * ratio;
+    canvas.height = height * ratio;
+
+    const ctx = canvas.getContext('2d');
+    ctx.scale(ratio, ratio);
+    ctx.clearRect(0, 0, width, height);
+
+    const hist = state.history;
+    if (!hist || hist.points.length === 0) {
+        return;
+    }
+
+    const style = getComputedStyle(document.documentElement);
+    const pad = {left: 70, right: 16, top: 12, bottom: 28};
+    const plotW = width - pad.left - pad.right;
+    const plotH = height - pad.top - pad.bottom;
+
+    const times = hist.points.map((pt) => new Date(pt.t).getTime());
+    const vals = hist.points.map((pt) => pt.v);
+    const minT = Date.now() - Number($('hist-range').value) * 1000;
+    const maxT = Date.now();
+    let minV = Math.min(0, ...vals);
+    let maxV = Math.max(...vals);
+    if (hist.metric.endsWith('Usage')) {
+        maxV = Math.max(maxV, 100);
+    }
+    if (maxV === minV) {
+        maxV = minV + 1;
+    }
+
+    const xOf = (time) => pad.left + (time - minT) / (maxT - minT) * plotW;
+    const yOf = (val) => pad.top + (1 - (val - minV) / (maxV - minV)) * plotH;
+
+    ctx.font = '11px system-ui, sans-serif';
+    ctx.fillStyle = style.getPropertyValue('--muted');
+    ctx.strokeStyle = style.getPropertyValue('--line');
+    ctx.lineWidth = 1;
+
+    ctx.textAlign = 'right';
+    ctx.textBaseline = 'middle';
+    for (let idx = 0; idx <= 4; idx++) {
+        const val = minV + (maxV - minV) * idx / 4;
+        const y = yOf(val);
+        ctx.beginPath();
+        ctx.moveTo(pad.left, y);
+        ctx.lineTo(width - pad.right, y);
+        ctx.stroke();
+        ctx.fillText(formatValue(hist.metric, val), pad.left - 6, y);
+    }
+
+    ctx.textAlign = 'center';
+    ctx.textBaseline = 'top';
+    const longRange = maxT - minT > 24 * 3600 * 1000;
+    for (let idx = 0; idx <= 4; idx++) {
+        const time = new Date(minT + (maxT - minT) * idx / 4);
+        const label = longRange ?
+            time.toLocaleDateString([], {month: 'short', day: 'numeric'}) :
+            time.toLocaleTimeString([], {hour: '2-digit', minute: '2-digit'});
+        ctx.fillText(label, xOf(time.getTime()), height - pad.bottom + 8);
+    }
+
+    // Gaps in the data longer than a few buckets are not bridged
+    const maxGap = hist.resolutionSecs * 3 * 1000;
+    ctx.strokeStyle = style.getPropertyValue('--accent');
+    ctx.lineWidth = 1.5;
+    ctx.beginPath();
+    for (let idx = 0; idx < times.length; idx++) {
+        const x = xOf(times[idx]);
+        const y = yOf(vals[idx]);
+        if (idx === 0 || times[idx] - times[idx - 1] > maxGap) {
+            ctx.moveTo(x, y);
+        } else {
+            ctx.lineTo(x, y);
+        }
+    }
+    ctx.stroke();
+}
+
+// ---- Login ----
+
+function renderUser() {
+    $('user').textContent = state.user || '';
+    $('login-btn').textContent = state.token ? 'Logout' : 'Login';
+}
+
+function login() {
+    return new Promise((resolve) => {
+        const dialog = $('login');
+        $('login-msg').textContent = '';
+        dialog.returnValue = '';
+
+        const onClose = async () => {
+            if (dialog.returnValue !== 'ok') {
+                resolve(false);
+                return;
+            }
+            const userId = $('login-user').value;
+            state.token = null;
+            try {
+                const res = await api('POST', '/auth/user', {
+                    userId: userId,
+                    password: $('login-pass').value,
+                });
+                state.token = res.token;
+                state.user = userId;
+                sessionStorage.setItem('picl.token', res.token);
+                sessionStorage.setItem('picl.user', userId);
+                $('login-pass').value = '';
+                renderUser();
+                resolve(true);
+            } catch (err) {
+                $('login-msg').textContent = 'Login failed: ' + err.message;
+                dialog.returnValue = '';
+                dialog.addEventListener('close', onClose, {once: true});
+                dialog.showModal();
+            }
+        };
+        dialog.addEventListener('close', onClose, {once: true});
+        dialog.showModal();
+    });
+}
+
+function logout() {
+    state.token = null;
+    state.user = null;
+    sessionStorage.removeItem('picl.token');
+    sessionStorage.removeItem('picl.user');
+    renderUser();
+}
+
+// ---- Startup ----
+
+$('login-btn').addEventListener('click', () => {
+    if (state.token) {
+        logout();
+    } else {
+        login();
+    }
+});
+for (const id of ['hist-node', 'hist-metric', 'hist-range']) {
+    $(id).addEventListener('change', loadHistory);
+}
+window.addEventListener('resize', drawChart);
+
+renderUser();
+connect();
+loadRelays();
+loadAlerts();
+setInterval(loadRelays, 10000);
+setInterval(loadAlerts, 5000);
+setInterval(loadHistory, 30000);
+// Keeps the "last seen" times current when nodes stop reporting
+setInterval(scheduleRender, 5000);
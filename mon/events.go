@@ -0,0 +1,89 @@
+package mon
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/varunamachi/libx/errx"
+)
+
+type EventType string
+
+const (
+	EventWatchdog EventType = "watchdog"
+	EventSchedule EventType = "schedule"
+	EventAlert    EventType = "alert"
+	EventAgent    EventType = "agent"
+)
+
+// Event - something noteworthy that happened in the cluster
+type Event struct {
+	Time time.Time `json:"time"`
+	Type EventType `json:"type"`
+	Node string    `json:"node"`
+	Msg  string    `json:"msg"`
+}
+
+// EventLog - appends events as JSON lines to a file. Events are also logged,
+// so an event log without a file is still useful, unless it is quiet because
+// a handler owns the console
+type EventLog struct {
+	mutex sync.Mutex
+	path  string
+	quiet bool
+}
+
+func NewEventLog(path string) *EventLog {
+	return &EventLog{path: path}
+}
+
+func (el *EventLog) Add(
+	evtType EventType, node string, msg string, args ...interface{}) {
+	if len(args) != 0 {
+		msg = fmt.Sprintf(msg, args...)
+	}
+	evt := &Event{
+		Time: time.Now(),
+		Type: evtType,
+		Node: node,
+		Msg:  msg,
+	}
+	if !el.quiet {
+		log.Info().Str("type", string(evtType)).Str("node", node).Msg(msg)
+	}
+
+	if err := el.write(evt); err != nil {
+		log.Error().Err(err).Msg("failed to write event log")
+	}
+}
+
+func (el *EventLog) write(evt *Event) error {
+	if el.path == "" {
+		return nil
+	}
+
+	el.mutex.Lock()
+	defer el.mutex.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(el.path), 0755); err != nil {
+		return errx.Errf(err, "failed to create directory for event log")
+	}
+	file, err := os.OpenFile(
+		el.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return errx.Errf(err, "failed to open event log '%s'", el.path)
+	}
+	defer file.Close()
+
+	data, err := json.Marshal(evt)
+	if err != nil {
+		return errx.Wrap(err)
+	}
+	_, err = file.Write(append(data, '\n'))
+	return errx.Wrap(err)
+}
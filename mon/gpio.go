@@ -0,0 +1,143 @@
+package mon
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stianeikeland/go-rpio/v4"
+	"github.com/varunamachi/libx/errx"
+)
+
+var (
+	ErrUnknownGpioDriver = errors.New("mon.gpio.unknownDriver")
+	ErrGpioUnsupported   = errors.New("mon.gpio.unsupported")
+	ErrGpioLevelUnknown  = errors.New("mon.gpio.levelUnknown")
+)
+
+type GpioDriverType string
+
+const (
+	// GpioRpio - memory mapped GPIO registers of Raspberry Pi via go-rpio
+	GpioRpio GpioDriverType = "rpio"
+
+	// GpioCdev - Linux GPIO character device, i.e. /dev/gpiochipN
+	GpioCdev GpioDriverType = "cdev"
+
+	// GpioSim - in memory simulation, only logs the state changes
+	GpioSim GpioDriverType = "sim"
+)
+
+const DefaultGpioChip = "/dev/gpiochip0"
+
+// Gpio - operations on GPIO pins required by the relay controller. Pins are
+// identified by their BCM number (line offset for character device). Output
+// configures the pin as output with given initial level, implementations
+// should avoid the pin glitching to any other level while doing so
+type Gpio interface {
+	Output(pin uint8, high bool) error
+	Write(pin uint8, high bool) error
+	Read(pin uint8) (bool, error)
+	Close() error
+}
+
+// NewGpio - opens the GPIO backend identified by the driver type. The chip is
+// used only by the character device driver
+func NewGpio(driver GpioDriverType, chip string) (Gpio, error) {
+	switch driver {
+	case GpioRpio, "":
+		return newRpioGpio()
+	case GpioCdev:
+		if chip == "" {
+			chip = DefaultGpioChip
+		}
+		return newCdevGpio(chip)
+	case GpioSim:
+		return newSimGpio(), nil
+	}
+	return nil, errx.Errf(ErrUnknownGpioDriver,
+		"unknown GPIO driver '%s', should be one of: rpio | cdev | sim",
+		driver)
+}
+
+type rpioGpio struct{}
+
+func newRpioGpio() (Gpio, error) {
+	if err := rpio.Open(); err != nil {
+		return nil, errx.Errf(err, "failed to open rpio")
+	}
+	return &rpioGpio{}, nil
+}
+
+func (rg *rpioGpio) Output(pin uint8, high bool) error {
+	// Setting the level first updates the output latch, so that the pin
+	// starts driving the intended level as soon as it becomes an output
+	rg.Write(pin, high)
+	rpio.Pin(pin).Output()
+	return nil
+}
+
+func (rg *rpioGpio) Write(pin uint8, high bool) error {
+	state := rpio.Low
+	if high {
+		state = rpio.High
+	}
+	rpio.Pin(pin).Write(state)
+	return nil
+}
+
+func (rg *rpioGpio) Read(pin uint8) (bool, error) {
+	return rpio.Pin(pin).Read() == rpio.High, nil
+}
+
+func (rg *rpioGpio) Close() error {
+	return rpio.Close()
+}
+
+type simGpio struct {
+	mutex  sync.Mutex
+	levels map[uint8]bool
+}
+
+func newSimGpio() Gpio {
+	log.Warn().Msg("using simulated GPIO, relays will not be switched")
+	return &simGpio{
+		levels: make(map[uint8]bool),
+	}
+}
+
+func (sg *simGpio) Output(pin uint8, high bool) error {
+	log.Debug().Uint8("pin", pin).Msg("sim-gpio: pin set to output")
+	return sg.Write(pin, high)
+}
+
+func (sg *simGpio) Write(pin uint8, high bool) error {
+	sg.mutex.Lock()
+	defer sg.mutex.Unlock()
+
+	prev, found := sg.levels[pin]
+	sg.levels[pin] = high
+	if !found || prev != high {
+		log.Info().
+			Uint8("pin", pin).
+			Bool("high", high).
+			Msg("sim-gpio: pin level changed")
+	}
+	return nil
+}
+
+func (sg *simGpio) Read(pin uint8) (bool, error) {
+	sg.mutex.Lock()
+	defer sg.mutex.Unlock()
+
+	high, found := sg.levels[pin]
+	if !found {
+		return false, errx.Errf(ErrGpioLevelUnknown,
+			"sim-gpio: level of pin %d is not known before it is written", pin)
+	}
+	return high, nil
+}
+
+func (sg *simGpio) Close() error {
+	return nil
+}
@@ -0,0 +1,153 @@
+//go:build linux
+
+package mon
+
+import (
+	"os"
+	"sync"
+	"unsafe"
+
+	"github.com/varunamachi/libx/errx"
+	"golang.org/x/sys/unix"
+)
+
+// Structures and ioctl numbers from linux/gpio.h (uAPI v1)
+const (
+	gpioHandlesMax          = 64
+	gpioHandleRequestOutput = 1 << 1
+
+	gpioGetLineHandleIoctl      = 0xC16CB403
+	gpioHandleGetLineValueIoctl = 0xC040B408
+	gpioHandleSetLineValueIoctl = 0xC040B409
+)
+
+type gpioHandleRequest struct {
+	LineOffsets   [gpioHandlesMax]uint32
+	Flags         uint32
+	DefaultValues [gpioHandlesMax]uint8
+	ConsumerLabel [32]byte
+	Lines         uint32
+	Fd            int32
+}
+
+type gpioHandleData struct {
+	Values [gpioHandlesMax]uint8
+}
+
+// cdevGpio - drives GPIO lines through the Linux GPIO character device. Lines
+// are requested as outputs with the initial level as default value, so the
+// kernel sets the level while switching the direction
+type cdevGpio struct {
+	mutex   sync.Mutex
+	chip    *os.File
+	handles map[uint8]int
+}
+
+func newCdevGpio(chip string) (Gpio, error) {
+	file, err := os.OpenFile(chip, os.O_RDWR, 0)
+	if err != nil {
+		return nil, errx.Errf(err, "failed to open GPIO chip '%s'", chip)
+	}
+	return &cdevGpio{
+		chip:    file,
+		handles: make(map[uint8]int),
+	}, nil
+}
+
+func (cg *cdevGpio) request(pin uint8, flags uint32, value bool) (int, error) {
+	req := gpioHandleRequest{
+		Flags: flags,
+		Lines: 1,
+	}
+	req.LineOffsets[0] = uint32(pin)
+	if value {
+		req.DefaultValues[0] = 1
+	}
+	copy(req.ConsumerLabel[:], "picl")
+
+	if err := ioctl(
+		cg.chip.Fd(), gpioGetLineHandleIoctl, unsafe.Pointer(&req)); err != nil {
+		return -1, errx.Errf(err, "failed to request GPIO line %d", pin)
+	}
+	return int(req.Fd), nil
+}
+
+func (cg *cdevGpio) Output(pin uint8, high bool) error {
+	cg.mutex.Lock()
+	defer cg.mutex.Unlock()
+
+	if fd, found := cg.handles[pin]; found {
+		unix.Close(fd)
+		delete(cg.handles, pin)
+	}
+	fd, err := cg.request(pin, gpioHandleRequestOutput, high)
+	if err != nil {
+		return err
+	}
+	cg.handles[pin] = fd
+	return nil
+}
+
+func (cg *cdevGpio) Write(pin uint8, high bool) error {
+	cg.mutex.Lock()
+	defer cg.mutex.Unlock()
+
+	fd, found := cg.handles[pin]
+	if !found {
+		return errx.Errf(ErrGpioUnsupported,
+			"GPIO line %d is not configured as output", pin)
+	}
+
+	data := gpioHandleData{}
+	if high {
+		data.Values[0] = 1
+	}
+	err := ioctl(
+		uintptr(fd), gpioHandleSetLineValueIoctl, unsafe.Pointer(&data))
+	if err != nil {
+		return errx.Errf(err, "failed to set value of GPIO line %d", pin)
+	}
+	return nil
+}
+
+func (cg *cdevGpio) Read(pin uint8) (bool, error) {
+	cg.mutex.Lock()
+	defer cg.mutex.Unlock()
+
+	fd, found := cg.handles[pin]
+	if !found {
+		// Requesting without direction flags keeps the line as is
+		var err error
+		fd, err = cg.request(pin, 0, false)
+		if err != nil {
+			return false, err
+		}
+		defer unix.Close(fd)
+	}
+
+	data := gpioHandleData{}
+	err := ioctl(
+		uintptr(fd), gpioHandleGetLineValueIoctl, unsafe.Pointer(&data))
+	if err != nil {
+		return false, errx.Errf(err, "failed to get value of GPIO line %d", pin)
+	}
+	return data.Values[0] != 0, nil
+}
+
+func (cg *cdevGpio) Close() error {
+	cg.mutex.Lock()
+	defer cg.mutex.Unlock()
+	for pin, fd := range cg.handles {
+		unix.Close(fd)
+		delete(cg.handles, pin)
+	}
+	return cg.chip.Close()
+}
+
+func ioctl(fd, req uintptr, arg unsafe.Pointer) error {
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, fd, req, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
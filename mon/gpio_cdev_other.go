@@ -0,0 +1,10 @@
+//go:build !linux
+
+package mon
+
+import "github.com/varunamachi/libx/errx"
+
+func newCdevGpio(chip string) (Gpio, error) {
+	return nil, errx.Errf(ErrGpioUnsupported,
+		"GPIO character device is supported only on Linux")
+}
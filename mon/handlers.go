@@ -0,0 +1,244 @@
+package mon
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"github.com/varunamachi/libx/errx"
+)
+
+var (
+	ErrInvalidHandler = errors.New("mon.handler.invalid")
+)
+
+// namedHandler - a handler of MultiHandler along with whether its last call
+// failed, so that failures are logged once rather than on every sample
+type namedHandler struct {
+	name    string
+	hdl     Handler
+	failing bool
+}
+
+// MultiHandler - passes every response to all of its handlers in the order
+// they were added. Errors of a handler are logged and do not stop the other
+// handlers or the monitor
+type MultiHandler struct {
+	handlers []*namedHandler
+}
+
+func NewMultiHandler() *MultiHandler {
+	return &MultiHandler{handlers: make([]*namedHandler, 0, 4)}
+}
+
+func (mh *MultiHandler) Add(name string, hdl Handler) *MultiHandler {
+	mh.handlers = append(mh.handlers, &namedHandler{name: name, hdl: hdl})
+	return mh
+}
+
+func (mh *MultiHandler) Handle(
+	gtx context.Context, resp *AgentResponse) error {
+	for _, nh := range mh.handlers {
+		err := nh.hdl.Handle(gtx, resp)
+		if gtx.Err() != nil {
+			return gtx.Err()
+		}
+
+		if err != nil && !nh.failing {
+			log.Error().Err(err).Str("handler", nh.name).
+				Msg("handler failed, continuing with the others")
+		} else if err == nil && nh.failing {
+			log.Info().Str("handler", nh.name).Msg("handler recovered")
+		}
+		nh.failing = err != nil
+	}
+	return nil
+}
+
+// SetRelayController - passes the controller on to the handlers that use it
+func (mh *MultiHandler) SetRelayController(rc *RelayController) {
+	for _, nh := range mh.handlers {
+		if ra, ok := nh.hdl.(RelayAware); ok {
+			ra.SetRelayController(rc)
+		}
+	}
+}
+
+// OwnsConsole - true if any of the handlers draws on the terminal
+func (mh *MultiHandler) OwnsConsole() bool {
+	for _, nh := range mh.handlers {
+		if co, ok := nh.hdl.(ConsoleOwner); ok && co.OwnsConsole() {
+			return true
+		}
+	}
+	return false
+}
+
+func (mh *MultiHandler) Close() error {
+	errs := make([]error, 0, len(mh.handlers))
+	for _, nh := range mh.handlers {
+		if err := nh.hdl.Close(); err != nil {
+			errs = append(errs, errx.Errf(err,
+				"failed to close handler '%s'", nh.name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// appendFile - opens the file for appending, creating it and its directory
+// when required. The file is opened for each write like the event log, so
+// that rotated or deleted files are recreated
+func appendFile(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, errx.Errf(err, "failed to create directory for '%s'", path)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, errx.Errf(err, "failed to open '%s'", path)
+	}
+	return file, nil
+}
+
+// sampleOf - the response in the form used by the stream endpoint
+func sampleOf(cfg *Config, resp *AgentResponse) *SampleEvent {
+	sample := &SampleEvent{
+		Index: resp.Index,
+		Up:    resp.Err == nil,
+		Time:  resp.Time,
+		Data:  resp.Data,
+	}
+	if resp.Index >= 0 && resp.Index < len(cfg.AgentConfig) {
+		sample.Node = cfg.AgentConfig[resp.Index].Name
+	}
+	if resp.Err != nil {
+		sample.Error = resp.Err.Error()
+	}
+	return sample
+}
+
+// jsonlHandler - appends every response to a file as a line of JSON, in the
+// same form as the samples from the stream endpoint
+type jsonlHandler struct {
+	mutex     sync.Mutex
+	monConfig *Config
+	path      string
+}
+
+func NewJSONLHandler(cfg *Config, path string) (
+	Handler, context.Context, error) {
+	if path == "" {
+		return nil, nil, errx.Errf(ErrInvalidHandler,
+			"jsonl handler needs the path of the file to write to")
+	}
+	return &jsonlHandler{
+		monConfig: cfg,
+		path:      path,
+	}, context.Background(), nil
+}
+
+func (jh *jsonlHandler) Handle(
+	gtx context.Context, resp *AgentResponse) error {
+	data, err := json.Marshal(sampleOf(jh.monConfig, resp))
+	if err != nil {
+		return errx.Wrap(err)
+	}
+
+	jh.mutex.Lock()
+	defer jh.mutex.Unlock()
+	file, err := appendFile(jh.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err = file.Write(append(data, '\n')); err != nil {
+		return errx.Errf(err, "failed to write to '%s'", jh.path)
+	}
+	return nil
+}
+
+func (jh *jsonlHandler) Close() error {
+	return nil
+}
+
+// csvHandler - appends every response to a CSV file with the metrics that
+// are kept in history as columns. Missing metrics are left empty
+type csvHandler struct {
+	mutex     sync.Mutex
+	monConfig *Config
+	path      string
+}
+
+func NewCSVHandler(cfg *Config, path string) (
+	Handler, context.Context, error) {
+	if path == "" {
+		return nil, nil, errx.Errf(ErrInvalidHandler,
+			"csv handler needs the path of the file to write to")
+	}
+	return &csvHandler{
+		monConfig: cfg,
+		path:      path,
+	}, context.Background(), nil
+}
+
+func (ch *csvHandler) Handle(
+	gtx context.Context, resp *AgentResponse) error {
+	sample := sampleOf(ch.monConfig, resp)
+	record := []string{
+		sample.Time.Format("2006-01-02T15:04:05.000Z07:00"),
+		sample.Node,
+		strconv.FormatBool(sample.Up),
+		sample.Error,
+	}
+	vals := make([]float64, len(historyMetrics))
+	if resp.Data != nil {
+		vals = metricValues(resp.Data)
+	} else {
+		for idx := range vals {
+			vals[idx] = math.NaN()
+		}
+	}
+	for _, val := range vals {
+		if math.IsNaN(val) {
+			record = append(record, "")
+			continue
+		}
+		record = append(record, strconv.FormatFloat(val, 'f', -1, 64))
+	}
+
+	ch.mutex.Lock()
+	defer ch.mutex.Unlock()
+	file, err := appendFile(ch.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	// Header goes in only when the file is new or was truncated
+	if stat, err := file.Stat(); err == nil && stat.Size() == 0 {
+		header := append([]string{"time", "node", "up", "error"},
+			historyMetrics...)
+		if err := writer.Write(header); err != nil {
+			return errx.Errf(err, "failed to write to '%s'", ch.path)
+		}
+	}
+	if err := writer.Write(record); err != nil {
+		return errx.Errf(err, "failed to write to '%s'", ch.path)
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return errx.Errf(err, "failed to write to '%s'", ch.path)
+	}
+	return nil
+}
+
+func (ch *csvHandler) Close() error {
+	return nil
+}
@@ -0,0 +1,529 @@
+package mon
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+	"github.com/varunamachi/libx/errx"
+	"github.com/varunamachi/libx/httpx"
+)
+
+var (
+	ErrUnknownMetric  = errors.New("mon.history.unknownMetric")
+	ErrUnknownNode    = errors.New("mon.history.unknownNode")
+	ErrInvalidHistory = errors.New("mon.history.invalid")
+)
+
+// historyMetrics - metrics recorded in history, the order decides the layout
+// of records in the files. Changing it requires bumping historyFormat
+var historyMetrics = []string{
+	"cpuTemp",
+	"cpuUsage",
+	"memUsage",
+	"swapUsage",
+	"load1",
+	"diskUsage",
+	"netRx",
+	"netTx",
+}
+
+const historyFormat = 1
+
+// HistoryTier - samples are averaged into buckets of ResolutionSecs and kept
+// for RetentionSecs
+type HistoryTier struct {
+	ResolutionSecs int `json:"resolutionSecs"`
+	RetentionSecs  int `json:"retentionSecs"`
+}
+
+// HistoryConfig - history is kept in ring buffer files, one per node and
+// tier, inside Dir. Tiers should be ordered from the finest resolution to
+// the coarsest
+type HistoryConfig struct {
+	Disabled bool           `json:"disabled"`
+	Dir      string         `json:"dir"`
+	Tiers    []*HistoryTier `json:"tiers"`
+}
+
+func DefaultHistoryTiers() []*HistoryTier {
+	return []*HistoryTier{
+		{ResolutionSecs: 10, RetentionSecs: 24 * 60 * 60},
+		{ResolutionSecs: 60, RetentionSecs: 7 * 24 * 60 * 60},
+		{ResolutionSecs: 10 * 60, RetentionSecs: 90 * 24 * 60 * 60},
+	}
+}
+
+// HistoryPoint - value of a metric at a time, time is the start of the bucket
+type HistoryPoint struct {
+	Time  time.Time `json:"t"`
+	Value float64   `json:"v"`
+}
+
+type HistoryResult struct {
+	Node           string          `json:"node"`
+	Metric         string          `json:"metric"`
+	ResolutionSecs int             `json:"resolutionSecs"`
+	Points         []*HistoryPoint `json:"points"`
+}
+
+// metricValues - values of the history metrics from a sample, NaN for the
+// ones the agent did not report
+func metricValues(info *SysInfo) []float64 {
+	vals := make([]float64, len(historyMetrics))
+	for idx := range vals {
+		vals[idx] = math.NaN()
+	}
+	if info.CPUTemp != nil {
+		vals[0] = *info.CPUTemp / 1000
+	}
+	vals[1] = info.CPUUsagePct
+	vals[2] = info.MemUsagePct
+	if info.Version >= 1 {
+		vals[3] = info.SwapUsagePct
+		if info.Load != nil {
+			vals[4] = info.Load.Load1
+		}
+		vals[5] = info.MaxDiskPct()
+		vals[6], vals[7] = info.NetTotals()
+	}
+	return vals
+}
+
+// ringFile - fixed size file with one slot per bucket of the tier. The slot
+// of a bucket is derived from its time, so no index is needed. Each record
+// is the bucket time in unix seconds followed by the metric values
+type ringFile struct {
+	tier     *HistoryTier
+	file     *os.File
+	capacity int64
+
+	// Bucket being aggregated
+	bucket int64
+	sums   []float64
+	counts []int
+}
+
+func recordSize() int64 {
+	return int64(8 * (1 + len(historyMetrics)))
+}
+
+func openRingFile(path string, tier *HistoryTier) (*ringFile, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, errx.Errf(err, "failed to open history file '%s'", path)
+	}
+	capacity := int64(tier.RetentionSecs / tier.ResolutionSecs)
+	if err := file.Truncate(capacity * recordSize()); err != nil {
+		file.Close()
+		return nil, errx.Errf(err, "failed to size history file '%s'", path)
+	}
+	return &ringFile{
+		tier:     tier,
+		file:     file,
+		capacity: capacity,
+		sums:     make([]float64, len(historyMetrics)),
+		counts:   make([]int, len(historyMetrics)),
+	}, nil
+}
+
+func (rf *ringFile) slotOf(bucket int64) int64 {
+	return (bucket / int64(rf.tier.ResolutionSecs)) % rf.capacity
+}
+
+func (rf *ringFile) bucketOf(at time.Time) int64 {
+	res := int64(rf.tier.ResolutionSecs)
+	return at.Unix() / res * res
+}
+
+// add - aggregates the values into the current bucket and writes the bucket
+// out once a sample from a later bucket arrives
+func (rf *ringFile) add(at time.Time, vals []float64) error {
+	bucket := rf.bucketOf(at)
+
+	var err error
+	if bucket != rf.bucket {
+		if rf.bucket != 0 {
+			err = rf.flush()
+		}
+		rf.bucket = bucket
+		clear(rf.sums)
+		clear(rf.counts)
+	}
+
+	for idx, val := range vals {
+		if !math.IsNaN(val) {
+			rf.sums[idx] += val
+			rf.counts[idx]++
+		}
+	}
+	return err
+}
+
+func (rf *ringFile) flush() error {
+	return rf.write(rf.bucket, rf.sums, rf.counts)
+}
+
+func (rf *ringFile) write(bucket int64, sums []float64, counts []int) error {
+	buf := make([]byte, recordSize())
+	binary.LittleEndian.PutUint64(buf, uint64(bucket))
+	for idx := range sums {
+		val := math.NaN()
+		if counts[idx] != 0 {
+			val = sums[idx] / float64(counts[idx])
+		}
+		binary.LittleEndian.PutUint64(buf[8*(idx+1):], math.Float64bits(val))
+	}
+	_, err := rf.file.WriteAt(buf, rf.slotOf(bucket)*recordSize())
+	return errx.Wrap(err)
+}
+
+// merge - adds the aggregated values of a bucket older than the one being
+// aggregated to its slot, leaving the current bucket alone. Records keep
+// only the averages, so a value already in the slot counts as one sample.
+// Slots already reused by a later round of the ring are not touched
+func (rf *ringFile) merge(bucket int64, sums []float64, counts []int) error {
+	buf := make([]byte, recordSize())
+	_, err := rf.file.ReadAt(buf, rf.slotOf(bucket)*recordSize())
+	if err != nil {
+		return errx.Errf(err, "failed to read history")
+	}
+	recorded := int64(binary.LittleEndian.Uint64(buf))
+	if recorded > bucket {
+		return nil
+	}
+	if recorded == bucket {
+		sums, counts = slices.Clone(sums), slices.Clone(counts)
+		for idx := range sums {
+			bits := binary.LittleEndian.Uint64(buf[8*(idx+1):])
+			if val := math.Float64frombits(bits); !math.IsNaN(val) {
+				sums[idx] += val
+				counts[idx]++
+			}
+		}
+	}
+	return rf.write(bucket, sums, counts)
+}
+
+// backfill - adds samples, ordered by time, that arrive late. The ones older
+// than the bucket being aggregated are summed up per bucket and merged into
+// their slots, later ones are aggregated as usual
+func (rf *ringFile) backfill(resps []*AgentResponse) error {
+	var errs []error
+	var pending int64
+	sums := make([]float64, len(historyMetrics))
+	counts := make([]int, len(historyMetrics))
+	mergePending := func() {
+		if pending == 0 {
+			return
+		}
+		if err := rf.merge(pending, sums, counts); err != nil {
+			errs = append(errs, err)
+		}
+		pending = 0
+		clear(sums)
+		clear(counts)
+	}
+
+	for _, resp := range resps {
+		vals := metricValues(resp.Data)
+		bucket := rf.bucketOf(resp.Time)
+		if rf.bucket == 0 || bucket >= rf.bucket {
+			mergePending()
+			if err := rf.add(resp.Time, vals); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		if bucket != pending {
+			mergePending()
+			pending = bucket
+		}
+		for idx, val := range vals {
+			if !math.IsNaN(val) {
+				sums[idx] += val
+				counts[idx]++
+			}
+		}
+	}
+	mergePending()
+	return errors.Join(errs...)
+}
+
+// read - gives the points of the metric between from and to. Slots holding
+// records from an earlier round of the ring are skipped
+func (rf *ringFile) read(
+	metric int, from, to time.Time) ([]*HistoryPoint, error) {
+	res := int64(rf.tier.ResolutionSecs)
+	start := from.Unix() / res * res
+	end := to.Unix()
+	if span := rf.capacity * res; end-start >= span {
+		start = (end - span + res) / res * res
+	}
+
+	points := make([]*HistoryPoint, 0, (end-start)/res+1)
+	buf := make([]byte, recordSize())
+	for bucket := start; bucket <= end; bucket += res {
+		_, err := rf.file.ReadAt(buf, rf.slotOf(bucket)*recordSize())
+		if err != nil {
+			return nil, errx.Errf(err, "failed to read history")
+		}
+		if int64(binary.LittleEndian.Uint64(buf)) != bucket {
+			continue
+		}
+		bits := binary.LittleEndian.Uint64(buf[8*(metric+1):])
+		if val := math.Float64frombits(bits); !math.IsNaN(val) {
+			points = append(points, &HistoryPoint{
+				Time:  time.Unix(bucket, 0),
+				Value: val,
+			})
+		}
+	}
+	return points, nil
+}
+
+type history struct {
+	mutex  sync.Mutex
+	cfg    *HistoryConfig
+	agents []*AgentConfig
+	rings  [][]*ringFile // node -> tier
+}
+
+func newHistory(cfg *HistoryConfig, agents []*AgentConfig) (*history, error) {
+	if cfg == nil || cfg.Disabled {
+		return nil, nil
+	}
+	if len(cfg.Tiers) == 0 {
+		cfg.Tiers = DefaultHistoryTiers()
+	}
+	for _, tier := range cfg.Tiers {
+		if tier.ResolutionSecs <= 0 ||
+			tier.RetentionSecs < tier.ResolutionSecs {
+			return nil, errx.Errf(ErrInvalidHistory,
+				"history tier should have a positive resolution and a "+
+					"retention longer than the resolution")
+		}
+	}
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, errx.Errf(err,
+			"failed to create history directory '%s'", cfg.Dir)
+	}
+
+	hist := &history{
+		cfg:    cfg,
+		agents: agents,
+		rings:  make([][]*ringFile, len(agents)),
+	}
+	for idx, agent := range agents {
+		hist.rings[idx] = make([]*ringFile, len(cfg.Tiers))
+		for tidx, tier := range cfg.Tiers {
+			name := fmt.Sprintf("%s.%ds.v%d.ring",
+				agent.Name, tier.ResolutionSecs, historyFormat)
+			ring, err := openRingFile(filepath.Join(cfg.Dir, name), tier)
+			if err != nil {
+				hist.close()
+				return nil, err
+			}
+			hist.rings[idx][tidx] = ring
+		}
+	}
+	return hist, nil
+}
+
+// record - adds a successful sample to all the tiers of the node
+func (hist *history) record(resp *AgentResponse) {
+	if hist == nil || resp.Err != nil || resp.Data == nil ||
+		resp.Index < 0 || resp.Index >= len(hist.rings) {
+		return
+	}
+
+	hist.mutex.Lock()
+	defer hist.mutex.Unlock()
+
+	at := resp.Time
+	if at.IsZero() {
+		at = time.Now()
+	}
+	vals := metricValues(resp.Data)
+	for _, ring := range hist.rings[resp.Index] {
+		if err := ring.add(at, vals); err != nil {
+			log.Error().Err(err).
+				Str("node", hist.agents[resp.Index].Name).
+				Msg("failed to write history")
+		}
+	}
+}
+
+// backfill - adds samples of a node that arrive late, such as the ones a push
+// mode agent buffered while offline, without disturbing the bucket being
+// aggregated from the live samples
+func (hist *history) backfill(index int, resps []*AgentResponse) {
+	if hist == nil || index < 0 || index >= len(hist.rings) {
+		return
+	}
+	resps = slices.DeleteFunc(
+		slices.Clone(resps), func(resp *AgentResponse) bool {
+			return resp.Err != nil || resp.Data == nil || resp.Time.IsZero()
+		})
+	slices.SortStableFunc(resps, func(a, b *AgentResponse) int {
+		return a.Time.Compare(b.Time)
+	})
+
+	hist.mutex.Lock()
+	defer hist.mutex.Unlock()
+
+	for _, ring := range hist.rings[index] {
+		if err := ring.backfill(resps); err != nil {
+			log.Error().Err(err).
+				Str("node", hist.agents[index].Name).
+				Msg("failed to write history")
+		}
+	}
+}
+
+// query - reads the metric from the finest tier whose retention covers the
+// start of the range
+func (hist *history) query(
+	node, metric string, from, to time.Time) (*HistoryResult, error) {
+	nodeIdx := slices.IndexFunc(hist.agents, func(ac *AgentConfig) bool {
+		return ac.Name == node
+	})
+	if nodeIdx < 0 {
+		return nil, errx.Errf(ErrUnknownNode, "unknown node '%s'", node)
+	}
+	metricIdx := slices.Index(historyMetrics, metric)
+	if metricIdx < 0 {
+		return nil, errx.Errf(ErrUnknownMetric,
+			"unknown metric '%s', should be one of: %v",
+			metric, historyMetrics)
+	}
+
+	rings := hist.rings[nodeIdx]
+	ring := rings[len(rings)-1]
+	age := time.Since(from)
+	for _, candidate := range rings {
+		if age <= time.Duration(candidate.tier.RetentionSecs)*time.Second {
+			ring = candidate
+			break
+		}
+	}
+
+	hist.mutex.Lock()
+	defer hist.mutex.Unlock()
+
+	points, err := ring.read(metricIdx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return &HistoryResult{
+		Node:           node,
+		Metric:         metric,
+		ResolutionSecs: ring.tier.ResolutionSecs,
+		Points:         points,
+	}, nil
+}
+
+func (hist *history) close() {
+	if hist == nil {
+		return
+	}
+	hist.mutex.Lock()
+	defer hist.mutex.Unlock()
+
+	for _, rings := range hist.rings {
+		for _, ring := range rings {
+			if ring == nil {
+				continue
+			}
+			if ring.bucket != 0 {
+				if err := ring.flush(); err != nil {
+					log.Error().Err(err).Msg("failed to flush history")
+				}
+			}
+			ring.file.Close()
+		}
+	}
+}
+
+// parseHistoryTime - accepts RFC3339 times and unix seconds
+func parseHistoryTime(str string, def time.Time) (time.Time, error) {
+	if str == "" {
+		return def, nil
+	}
+	if secs, err := strconv.ParseInt(str, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	at, err := time.Parse(time.RFC3339, str)
+	if err != nil {
+		return time.Time{}, errx.Errf(ErrInvalidHistory,
+			"invalid time '%s', should be RFC3339 or unix seconds", str)
+	}
+	return at, nil
+}
+
+func getHistoryEndpoints(hist *history) []*httpx.Endpoint {
+	return []*httpx.Endpoint{
+		{
+			Method:   echo.GET,
+			Path:     "/history",
+			Category: "history",
+			Desc: "Get history of a metric of a node, query params: " +
+				"node, metric, from and to",
+			Version: "v1",
+			Handler: func(etx echo.Context) error {
+				if hist == nil {
+					return &echo.HTTPError{
+						Message: "history is not enabled",
+						Code:    http.StatusNotFound,
+					}
+				}
+
+				now := time.Now()
+				from, err := parseHistoryTime(
+					etx.QueryParam("from"), now.Add(-time.Hour))
+				if err != nil {
+					return &echo.HTTPError{
+						Message:  "invalid from time",
+						Code:     http.StatusBadRequest,
+						Internal: err,
+					}
+				}
+				to, err := parseHistoryTime(etx.QueryParam("to"), now)
+				if err != nil || to.Before(from) {
+					return &echo.HTTPError{
+						Message:  "invalid to time",
+						Code:     http.StatusBadRequest,
+						Internal: err,
+					}
+				}
+
+				res, err := hist.query(
+					etx.QueryParam("node"), etx.QueryParam("metric"),
+					from, to)
+				if err != nil {
+					code := http.StatusInternalServerError
+					if errors.Is(err, ErrUnknownNode) ||
+						errors.Is(err, ErrUnknownMetric) {
+						code = http.StatusBadRequest
+					}
+					return &echo.HTTPError{
+						Message:  "failed to get history",
+						Code:     code,
+						Internal: err,
+					}
+				}
+				return httpx.SendJSON(etx, res)
+			},
+		},
+	}
+}
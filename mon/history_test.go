@@ -0,0 +1,86 @@
+package mon
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestHistory(t *testing.T) *history {
+	t.Helper()
+	hist, err := newHistory(&HistoryConfig{
+		Dir:   t.TempDir(),
+		Tiers: []*HistoryTier{{ResolutionSecs: 10, RetentionSecs: 3600}},
+	}, []*AgentConfig{{Name: "pi1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(hist.close)
+	return hist
+}
+
+func usageResponse(at time.Time, usage float64) *AgentResponse {
+	return &AgentResponse{
+		Data: &SysInfo{CPUUsagePct: usage},
+		Time: at,
+	}
+}
+
+func expectPoints(
+	t *testing.T, hist *history, from, to time.Time, want ...float64) {
+	t.Helper()
+	res, err := hist.query("pi1", "cpuUsage", from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Points) != len(want) {
+		t.Fatalf("expected %d points, got %d", len(want), len(res.Points))
+	}
+	for idx, pt := range res.Points {
+		if pt.Value != want[idx] {
+			t.Errorf("point %d at %s: expected %v, got %v",
+				idx, pt.Time, want[idx], pt.Value)
+		}
+	}
+}
+
+func TestHistoryBackfillKeepsLiveBucket(t *testing.T) {
+	hist := newTestHistory(t)
+	now := time.Now().Truncate(10 * time.Second)
+
+	// Live bucket being aggregated
+	hist.record(usageResponse(now, 10))
+	hist.record(usageResponse(now.Add(time.Second), 20))
+
+	// Late samples from two older buckets
+	hist.backfill(0, []*AgentResponse{
+		usageResponse(now.Add(-20*time.Second), 30),
+		usageResponse(now.Add(-19*time.Second), 50),
+		usageResponse(now.Add(-10*time.Second), 60),
+	})
+
+	// Live bucket keeps aggregating and is written once it is done
+	hist.record(usageResponse(now.Add(2*time.Second), 30))
+	hist.record(usageResponse(now.Add(10*time.Second), 0))
+
+	expectPoints(t, hist, now.Add(-20*time.Second), now, 40, 60, 20)
+}
+
+func TestHistoryBackfillMergesBuckets(t *testing.T) {
+	hist := newTestHistory(t)
+	now := time.Now().Truncate(10 * time.Second)
+	old := now.Add(-30 * time.Second)
+
+	hist.record(usageResponse(now, 10))
+
+	// Samples of a bucket coming in two requests are merged
+	hist.backfill(0, []*AgentResponse{usageResponse(old, 20)})
+	hist.backfill(0, []*AgentResponse{usageResponse(old.Add(time.Second), 40)})
+	expectPoints(t, hist, old, old, 30)
+
+	// Later samples move the live bucket ahead like live ones
+	hist.backfill(0, []*AgentResponse{
+		usageResponse(now.Add(5*time.Second), 30),
+		usageResponse(now.Add(10*time.Second), 50),
+	})
+	expectPoints(t, hist, now, now, 20)
+}
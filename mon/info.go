@@ -2,35 +2,87 @@ package mon
 
 import (
 	"context"
+	"fmt"
 	"os"
+	"os/exec"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/rs/zerolog/log"
 	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/load"
 	"github.com/shirou/gopsutil/v3/mem"
+	"github.com/shirou/gopsutil/v3/net"
 )
 
+// SysInfoVersion - version of the SysInfo payload sent by the agent. Agents
+// older than version 1 only send temperature, CPU and memory usage. From
+// version 2 CPU temperature is absent when there is no sensor for it
+const SysInfoVersion = 2
+
 type SysInfo struct {
-	CPUTemp     float64 `json:"cpuTemp"`
-	CPUUsagePct float64 `json:"cpuUsage"`
-	MemUsagePct float64 `json:"memUsage"`
+	Version     int      `json:"version"`
+	CPUTemp     *float64 `json:"cpuTemp,omitempty"`
+	CPUUsagePct float64  `json:"cpuUsage"`
+	MemUsagePct float64  `json:"memUsage"`
+
+	CPUCoresPct  []float64    `json:"cpuCores,omitempty"`
+	SwapUsagePct float64      `json:"swapUsage"`
+	Load         *LoadAvg     `json:"load,omitempty"`
+	Disks        []*DiskUsage `json:"disks,omitempty"`
+	Net          []*NetRate   `json:"net,omitempty"`
+	Throttling   *Throttling  `json:"throttling,omitempty"`
+	Sensors      []*Sensor    `json:"sensors,omitempty"`
+	Fans         []*Fan       `json:"fans,omitempty"`
 }
 
-func systemInfo(gtx context.Context) (*SysInfo, error) {
-	temp, err := os.ReadFile(
-		"/sys/class/thermal/thermal_zone0/temp")
+type LoadAvg struct {
+	Load1  float64 `json:"load1"`
+	Load5  float64 `json:"load5"`
+	Load15 float64 `json:"load15"`
+}
 
-	info := SysInfo{}
-	if err != nil {
-		return &info, err
-	}
+type DiskUsage struct {
+	Mount   string  `json:"mount"`
+	FsType  string  `json:"fsType"`
+	Total   uint64  `json:"total"`
+	Used    uint64  `json:"used"`
+	UsedPct float64 `json:"usedPct"`
+}
 
-	strTemp := strings.TrimSpace(string(temp))
-	info.CPUTemp, err = strconv.ParseFloat(strTemp, 64)
+// NetRate - receive and transmit rates of an interface in bytes per second,
+// computed from the counters seen in the previous request
+type NetRate struct {
+	Iface  string  `json:"iface"`
+	RxRate float64 `json:"rxRate"`
+	TxRate float64 `json:"txRate"`
+}
 
-	if err != nil {
-		return &info, err
+// Throttling - decoded value of 'vcgencmd get_throttled'. The first set of
+// flags tells the current state and the second whether it has happened since
+// boot
+type Throttling struct {
+	Raw             uint32 `json:"raw"`
+	UnderVoltage    bool   `json:"underVoltage"`
+	FreqCapped      bool   `json:"freqCapped"`
+	Throttled       bool   `json:"throttled"`
+	SoftTempLimit   bool   `json:"softTempLimit"`
+	UnderVoltageOcc bool   `json:"underVoltageOccurred"`
+	FreqCappedOcc   bool   `json:"freqCappedOccurred"`
+	ThrottledOcc    bool   `json:"throttledOccurred"`
+	SoftTempOcc     bool   `json:"softTempLimitOccurred"`
+}
+
+func systemInfo(gtx context.Context) (*SysInfo, error) {
+	info := SysInfo{Version: SysInfoVersion}
+	if readings, err := sensorCache.get(gtx); err == nil {
+		info.Sensors, info.Fans = readings.sensors, readings.fans
 	}
+	info.CPUTemp = cpuTemp(info.Sensors)
 
 	vmem, err := mem.VirtualMemoryWithContext(gtx)
 	if err != nil {
@@ -44,5 +96,214 @@ func systemInfo(gtx context.Context) (*SysInfo, error) {
 	}
 	info.CPUUsagePct = usage[0]
 
+	// Rest of the metrics are best effort, a missing one should not hide the
+	// others
+	if info.CPUCoresPct, err = cpu.PercentWithContext(gtx, 0, true); err != nil {
+		log.Debug().Err(err).Msg("failed to get per core CPU usage")
+	}
+	if swap, err := mem.SwapMemoryWithContext(gtx); err == nil {
+		info.SwapUsagePct = swap.UsedPercent
+	} else {
+		log.Debug().Err(err).Msg("failed to get swap usage")
+	}
+	if avg, err := load.AvgWithContext(gtx); err == nil {
+		info.Load = &LoadAvg{
+			Load1:  avg.Load1,
+			Load5:  avg.Load5,
+			Load15: avg.Load15,
+		}
+	} else {
+		log.Debug().Err(err).Msg("failed to get load average")
+	}
+	if info.Disks, err = diskCache.get(gtx); err != nil {
+		log.Debug().Err(err).Msg("failed to get disk usage")
+	}
+	if info.Net, err = netRates.sample(gtx); err != nil {
+		log.Debug().Err(err).Msg("failed to get network rates")
+	}
+	if info.Throttling, err = throttlingCache.get(gtx); err != nil {
+		log.Debug().Err(err).Msg("failed to get throttling state")
+	}
+
 	return &info, nil
 }
+
+func diskUsages(gtx context.Context) ([]*DiskUsage, error) {
+	parts, err := disk.PartitionsWithContext(gtx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	usages := make([]*DiskUsage, 0, len(parts))
+	seen := map[string]bool{}
+	for _, part := range parts {
+		// Snaps and the like are read only images that are always full
+		if part.Fstype == "squashfs" || seen[part.Mountpoint] {
+			continue
+		}
+		seen[part.Mountpoint] = true
+
+		usage, err := disk.UsageWithContext(gtx, part.Mountpoint)
+		if err != nil || usage.Total == 0 {
+			continue
+		}
+		usages = append(usages, &DiskUsage{
+			Mount:   part.Mountpoint,
+			FsType:  part.Fstype,
+			Total:   usage.Total,
+			Used:    usage.Used,
+			UsedPct: usage.UsedPercent,
+		})
+	}
+	return usages, nil
+}
+
+type netSampler struct {
+	mutex    sync.Mutex
+	prevTime time.Time
+	prev     map[string]net.IOCountersStat
+}
+
+var netRates = &netSampler{}
+
+// sample - gives the rates since the previous sample. The first sample only
+// records the counters, hence rates are reported as zero
+func (ns *netSampler) sample(gtx context.Context) ([]*NetRate, error) {
+	counters, err := net.IOCountersWithContext(gtx, true)
+	if err != nil {
+		return nil, err
+	}
+
+	ns.mutex.Lock()
+	defer ns.mutex.Unlock()
+
+	now := time.Now()
+	elapsed := now.Sub(ns.prevTime).Seconds()
+	rates := make([]*NetRate, 0, len(counters))
+	cur := make(map[string]net.IOCountersStat, len(counters))
+	for _, ctr := range counters {
+		if ctr.Name == "lo" {
+			continue
+		}
+		cur[ctr.Name] = ctr
+		rate := &NetRate{Iface: ctr.Name}
+		prev, found := ns.prev[ctr.Name]
+		if found && elapsed > 0 &&
+			ctr.BytesRecv >= prev.BytesRecv && ctr.BytesSent >= prev.BytesSent {
+			rate.RxRate = float64(ctr.BytesRecv-prev.BytesRecv) / elapsed
+			rate.TxRate = float64(ctr.BytesSent-prev.BytesSent) / elapsed
+		}
+		rates = append(rates, rate)
+	}
+	slices.SortFunc(rates, func(a, b *NetRate) int {
+		return strings.Compare(a.Iface, b.Iface)
+	})
+
+	ns.prev = cur
+	ns.prevTime = now
+	return rates, nil
+}
+
+const throttledSysfs = "/sys/devices/platform/soc/soc:firmware/get_throttled"
+
+// throttling - reads the throttling flags from sysfs where the firmware
+// driver exposes them and falls back to vcgencmd otherwise. Nil is returned
+// on systems that are not Raspberry Pis
+func throttling(gtx context.Context) (*Throttling, error) {
+	var raw string
+	if data, err := os.ReadFile(throttledSysfs); err == nil {
+		raw = strings.TrimSpace(string(data))
+	} else {
+		if _, err := exec.LookPath("vcgencmd"); err != nil {
+			return nil, nil
+		}
+		out, err := exec.CommandContext(
+			gtx, "vcgencmd", "get_throttled").Output()
+		if err != nil {
+			return nil, err
+		}
+		// Output looks like: throttled=0x50005
+		_, raw, _ = strings.Cut(strings.TrimSpace(string(out)), "=")
+	}
+
+	val, err := strconv.ParseUint(strings.TrimPrefix(raw, "0x"), 16, 32)
+	if err != nil {
+		return nil, err
+	}
+	flags := uint32(val)
+	bit := func(pos uint) bool { return flags&(1<<pos) != 0 }
+	return &Throttling{
+		Raw:             flags,
+		UnderVoltage:    bit(0),
+		FreqCapped:      bit(1),
+		Throttled:       bit(2),
+		SoftTempLimit:   bit(3),
+		UnderVoltageOcc: bit(16),
+		FreqCappedOcc:   bit(17),
+		ThrottledOcc:    bit(18),
+		SoftTempOcc:     bit(19),
+	}, nil
+}
+
+// Flags - short form of the active throttling flags, used in displays
+func (th *Throttling) Flags() string {
+	if th == nil {
+		return ""
+	}
+	flags := make([]string, 0, 4)
+	if th.UnderVoltage {
+		flags = append(flags, "UV")
+	}
+	if th.FreqCapped {
+		flags = append(flags, "CAP")
+	}
+	if th.Throttled {
+		flags = append(flags, "THR")
+	}
+	if th.SoftTempLimit {
+		flags = append(flags, "TMP")
+	}
+	if len(flags) == 0 && th.Raw != 0 {
+		flags = append(flags, "past")
+	}
+	return strings.Join(flags, ",")
+}
+
+// MaxDiskPct - usage of the fullest filesystem
+func (si *SysInfo) MaxDiskPct() float64 {
+	max := 0.0
+	for _, du := range si.Disks {
+		if du.UsedPct > max {
+			max = du.UsedPct
+		}
+	}
+	return max
+}
+
+// NetTotals - sum of receive and transmit rates of all the interfaces
+func (si *SysInfo) NetTotals() (rx, tx float64) {
+	for _, nr := range si.Net {
+		rx += nr.RxRate
+		tx += nr.TxRate
+	}
+	return rx, tx
+}
+
+// humanRate - formats bytes per second with a binary unit
+func humanRate(rate float64) string {
+	units := []string{"B", "K", "M", "G"}
+	idx := 0
+	for rate >= 1024 && idx < len(units)-1 {
+		rate /= 1024
+		idx++
+	}
+	return fmt.Sprintf("%.1f%s", rate, units[idx])
+}
+
+// FormatTemp - CPU temperature in degree celsius for displays
+func (si *SysInfo) FormatTemp() string {
+	if si.CPUTemp == nil {
+		return "N/A"
+	}
+	return fmt.Sprintf("%.2f", *si.CPUTemp/1000)
+}
@@ -0,0 +1,277 @@
+package mon
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+	"github.com/varunamachi/libx/errx"
+	"github.com/varunamachi/libx/httpx"
+)
+
+var (
+	ErrInvalidLogQuery = errors.New("mon.logs.invalidQuery")
+	ErrLogStream       = errors.New("mon.logs.stream")
+)
+
+const (
+	defaultLogLines = 10
+	maxLogLines     = 10000
+)
+
+// logFiles - files that can be tailed through the agent. Arbitrary paths are
+// not allowed since the agent usually runs as root
+var logFiles = map[string]string{
+	"picl":   "/opt/bin/picl.log",
+	"syslog": "/var/log/syslog",
+}
+
+var logPriorities = []string{
+	"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug",
+}
+
+// LogQuery - selects the logs to stream. Journal is read unless a File is
+// given, Unit and Priority apply only to the journal
+type LogQuery struct {
+	Unit     string `json:"unit"`
+	Priority string `json:"priority"`
+	File     string `json:"file"`
+	Lines    int    `json:"lines"`
+	Follow   bool   `json:"follow"`
+}
+
+func (lq *LogQuery) values() url.Values {
+	vals := url.Values{}
+	if lq.Unit != "" {
+		vals.Set("unit", lq.Unit)
+	}
+	if lq.Priority != "" {
+		vals.Set("priority", lq.Priority)
+	}
+	if lq.File != "" {
+		vals.Set("file", lq.File)
+	}
+	if lq.Lines != 0 {
+		vals.Set("lines", strconv.Itoa(lq.Lines))
+	}
+	if lq.Follow {
+		vals.Set("follow", "true")
+	}
+	return vals
+}
+
+func toLogQuery(etx echo.Context) (*LogQuery, error) {
+	lq := &LogQuery{
+		Unit:     etx.QueryParam("unit"),
+		Priority: etx.QueryParam("priority"),
+		File:     etx.QueryParam("file"),
+		Lines:    defaultLogLines,
+		Follow:   etx.QueryParam("follow") == "true",
+	}
+	if lines := etx.QueryParam("lines"); lines != "" {
+		var err error
+		lq.Lines, err = strconv.Atoi(lines)
+		if err != nil || lq.Lines < 0 || lq.Lines > maxLogLines {
+			return nil, errx.Errf(ErrInvalidLogQuery,
+				"lines should be a number between 0 and %d", maxLogLines)
+		}
+	}
+	return lq, nil
+}
+
+// command - gives the command that prints the logs selected by the query
+func (lq *LogQuery) command(gtx context.Context) (*exec.Cmd, error) {
+	if lq.File != "" {
+		path, found := logFiles[lq.File]
+		if !found {
+			return nil, errx.Errf(ErrInvalidLogQuery,
+				"unknown log file '%s', should be one of: picl | syslog",
+				lq.File)
+		}
+		args := []string{"-n", strconv.Itoa(lq.Lines)}
+		if lq.Follow {
+			args = append(args, "-F")
+		}
+		return exec.CommandContext(
+			gtx, "tail", append(args, "--", path)...), nil
+	}
+
+	args := []string{
+		"--no-pager", "--output=short-iso", "-n", strconv.Itoa(lq.Lines),
+	}
+	if lq.Follow {
+		args = append(args, "--follow")
+	}
+	if lq.Unit != "" {
+		if err := validateUnit(lq.Unit); err != nil {
+			return nil, err
+		}
+		args = append(args, "--unit="+lq.Unit)
+	}
+	if lq.Priority != "" {
+		if !isLogPriority(lq.Priority) {
+			return nil, errx.Errf(ErrInvalidLogQuery,
+				"invalid priority '%s', should be 0-7 or one of: %s",
+				lq.Priority, strings.Join(logPriorities, " | "))
+		}
+		args = append(args, "--priority="+lq.Priority)
+	}
+	return exec.CommandContext(gtx, "journalctl", args...), nil
+}
+
+func isLogPriority(prio string) bool {
+	for idx, name := range logPriorities {
+		if prio == name || prio == strconv.Itoa(idx) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleLogs - streams the log lines as server sent events, each line being
+// the data of an event. The command is killed when the client goes away
+func handleLogs(etx echo.Context) error {
+	lq, err := toLogQuery(etx)
+	if err != nil {
+		return &echo.HTTPError{
+			Code:     http.StatusBadRequest,
+			Message:  "invalid log query",
+			Internal: err,
+		}
+	}
+
+	gtx := etx.Request().Context()
+	cmd, err := lq.command(gtx)
+	if err != nil {
+		return &echo.HTTPError{
+			Code:     http.StatusBadRequest,
+			Message:  "invalid log query",
+			Internal: err,
+		}
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return errx.Wrap(err)
+	}
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Start(); err != nil {
+		return &echo.HTTPError{
+			Code:     http.StatusInternalServerError,
+			Message:  "failed to read logs",
+			Internal: err,
+		}
+	}
+	defer cmd.Wait()
+
+	res := etx.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set(echo.HeaderConnection, "keep-alive")
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		_, err := fmt.Fprintf(res, "data: %s\n\n", scanner.Text())
+		if err != nil {
+			return nil
+		}
+		res.Flush()
+	}
+	fmt.Fprint(res, "event: end\ndata: \n\n")
+	res.Flush()
+	return nil
+}
+
+func getLogEndpoints() []*httpx.Endpoint {
+	return []*httpx.Endpoint{
+		{
+			Method:   echo.GET,
+			Path:     "/logs",
+			Category: "logs",
+			Desc:     "Stream journal or log file as server sent events",
+			Version:  "v0",
+			Handler:  handleLogs,
+		},
+	}
+}
+
+// StreamLogs - streams the logs selected by the query from an agent and
+// writes the lines to the writer until the stream ends or the context is
+// cancelled
+func StreamLogs(
+	gtx context.Context,
+	agent *AgentConfig,
+	query *LogQuery,
+	writer io.Writer) error {
+	token := ""
+	if agent.AuthData != nil {
+		client := httpx.NewCustomClient(
+			agent.Address, "", httpx.DefaultTransport(), 10*time.Second)
+		var err error
+		token, err = requestToken(gtx, client, *agent.AuthData)
+		if err != nil {
+			return errx.Errf(err,
+				"failed to login to agent '%s'", agent.Name)
+		}
+	}
+
+	reqUrl := strings.TrimSuffix(agent.Address, "/") + "/api/v0/logs?" +
+		query.values().Encode()
+	req, err := http.NewRequestWithContext(gtx, http.MethodGet, reqUrl, nil)
+	if err != nil {
+		return errx.Errf(err, "failed to create log request")
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	// No timeout, a followed stream does not end by itself
+	client := &http.Client{Transport: httpx.DefaultTransport()}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errx.Errf(err, "failed to connect to agent '%s'", agent.Name)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errx.Errf(ErrLogStream,
+			"agent '%s' responded with %s", agent.Name, resp.Status)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	event := ""
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			if event == "end" {
+				return nil
+			}
+			if _, err := fmt.Fprintln(
+				writer, strings.TrimPrefix(line, "data: ")); err != nil {
+				return errx.Wrap(err)
+			}
+		case line == "":
+			event = ""
+		}
+	}
+	if err := scanner.Err(); err != nil && gtx.Err() == nil {
+		log.Debug().Err(err).Str("agent", agent.Name).Msg("log stream")
+		return errx.Errf(err, "log stream from '%s' broke", agent.Name)
+	}
+	return nil
+}
@@ -0,0 +1,283 @@
+package mon
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+	"github.com/varunamachi/libx/httpx"
+)
+
+const openMetricsType = "application/openmetrics-text; version=1.0.0; " +
+	"charset=utf-8"
+
+// label - a label of a metric sample, kept as a slice to have a stable order
+type label struct {
+	name, value string
+}
+
+type sample struct {
+	labels []label
+	value  float64
+}
+
+// metricFamily - samples of a metric, OpenMetrics requires all the samples
+// of a family to be written together
+type metricFamily struct {
+	name    string
+	help    string
+	samples []sample
+}
+
+func (mf *metricFamily) add(value float64, labels ...label) {
+	mf.samples = append(mf.samples, sample{labels: labels, value: value})
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func writeFamilies(w io.Writer, families []*metricFamily) error {
+	var sb strings.Builder
+	for _, mf := range families {
+		if len(mf.samples) == 0 {
+			continue
+		}
+		fmt.Fprintf(&sb, "# TYPE %s gauge\n# HELP %s %s\n",
+			mf.name, mf.name, mf.help)
+		for _, smp := range mf.samples {
+			sb.WriteString(mf.name)
+			if len(smp.labels) != 0 {
+				sb.WriteByte('{')
+				for idx, lbl := range smp.labels {
+					if idx != 0 {
+						sb.WriteByte(',')
+					}
+					fmt.Fprintf(&sb, `%s="%s"`,
+						lbl.name, labelEscaper.Replace(lbl.value))
+				}
+				sb.WriteByte('}')
+			}
+			sb.WriteByte(' ')
+			sb.WriteString(strconv.FormatFloat(smp.value, 'g', -1, 64))
+			sb.WriteByte('\n')
+		}
+	}
+	sb.WriteString("# EOF\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func boolValue(val bool) float64 {
+	if val {
+		return 1
+	}
+	return 0
+}
+
+// sysInfoFamilies - metric families for the samples of the given nodes, each
+// sample labeled with name of its node. Nil samples are skipped
+func sysInfoFamilies(nodes []string, infos []*SysInfo) []*metricFamily {
+	temp := &metricFamily{name: "picl_cpu_temperature_celsius",
+		help: "CPU temperature"}
+	cpu := &metricFamily{name: "picl_cpu_usage_percent",
+		help: "CPU usage across all cores"}
+	core := &metricFamily{name: "picl_cpu_core_usage_percent",
+		help: "CPU usage of a core"}
+	memory := &metricFamily{name: "picl_memory_usage_percent",
+		help: "Memory usage"}
+	swap := &metricFamily{name: "picl_swap_usage_percent",
+		help: "Swap usage"}
+	load := &metricFamily{name: "picl_load_average",
+		help: "System load average"}
+	fsPct := &metricFamily{name: "picl_filesystem_usage_percent",
+		help: "Filesystem usage"}
+	fsSize := &metricFamily{name: "picl_filesystem_size_bytes",
+		help: "Filesystem size"}
+	fsUsed := &metricFamily{name: "picl_filesystem_used_bytes",
+		help: "Used space in filesystem"}
+	rx := &metricFamily{name: "picl_network_receive_bytes_per_second",
+		help: "Network receive rate"}
+	tx := &metricFamily{name: "picl_network_transmit_bytes_per_second",
+		help: "Network transmit rate"}
+	throttled := &metricFamily{name: "picl_throttled",
+		help: "Raspberry Pi throttling flags, 1 when active"}
+	sensor := &metricFamily{name: "picl_sensor_temperature_celsius",
+		help: "Temperature reported by a thermal zone or hwmon sensor"}
+	fan := &metricFamily{name: "picl_fan_speed_rpm",
+		help: "Fan speed"}
+
+	for idx, info := range infos {
+		if info == nil {
+			continue
+		}
+		node := label{"node", nodes[idx]}
+		if info.CPUTemp != nil {
+			temp.add(*info.CPUTemp/1000, node)
+		}
+		cpu.add(info.CPUUsagePct, node)
+		memory.add(info.MemUsagePct, node)
+		if info.Version < 1 {
+			continue
+		}
+
+		for cidx, pct := range info.CPUCoresPct {
+			core.add(pct, node, label{"core", strconv.Itoa(cidx)})
+		}
+		swap.add(info.SwapUsagePct, node)
+		if info.Load != nil {
+			load.add(info.Load.Load1, node, label{"period", "1m"})
+			load.add(info.Load.Load5, node, label{"period", "5m"})
+			load.add(info.Load.Load15, node, label{"period", "15m"})
+		}
+		for _, du := range info.Disks {
+			mount := label{"mount", du.Mount}
+			fsType := label{"fstype", du.FsType}
+			fsPct.add(du.UsedPct, node, mount, fsType)
+			fsSize.add(float64(du.Total), node, mount, fsType)
+			fsUsed.add(float64(du.Used), node, mount, fsType)
+		}
+		for _, nr := range info.Net {
+			rx.add(nr.RxRate, node, label{"iface", nr.Iface})
+			tx.add(nr.TxRate, node, label{"iface", nr.Iface})
+		}
+		if th := info.Throttling; th != nil {
+			flags := []struct {
+				name string
+				on   bool
+			}{
+				{"under_voltage", th.UnderVoltage},
+				{"freq_capped", th.FreqCapped},
+				{"throttled", th.Throttled},
+				{"soft_temp_limit", th.SoftTempLimit},
+			}
+			for _, flag := range flags {
+				throttled.add(boolValue(flag.on), node, label{"flag", flag.name})
+			}
+		}
+		for _, sn := range info.Sensors {
+			sensor.add(sn.Temp, node,
+				label{"source", sn.Source},
+				label{"device", sn.Device},
+				label{"label", sn.Label})
+		}
+		for _, fn := range info.Fans {
+			fan.add(float64(fn.RPM), node,
+				label{"device", fn.Device},
+				label{"label", fn.Label})
+		}
+	}
+
+	return []*metricFamily{
+		temp, cpu, core, memory, swap, load, fsPct, fsSize, fsUsed,
+		rx, tx, throttled, sensor, fan,
+	}
+}
+
+func sendMetrics(etx echo.Context, families []*metricFamily) error {
+	etx.Response().Header().Set(echo.HeaderContentType, openMetricsType)
+	etx.Response().WriteHeader(http.StatusOK)
+	return writeFamilies(etx.Response(), families)
+}
+
+// getAgentMetricsEndpoint - per node metrics of the agent, labeled with the
+// name of the node. The last sample is served, a new one is taken only when
+// it is too old
+func getAgentMetricsEndpoint(node string) *httpx.Endpoint {
+	return &httpx.Endpoint{
+		Method:   echo.GET,
+		Path:     "/metrics",
+		Category: "metrics",
+		Desc:     "Metrics of the node in OpenMetrics text format",
+		Handler: func(etx echo.Context) error {
+			info, err := sampleCache.get(etx.Request().Context())
+			if err != nil {
+				return &echo.HTTPError{
+					Code:     http.StatusInternalServerError,
+					Message:  "failed to collect metrics",
+					Internal: err,
+				}
+			}
+			return sendMetrics(etx, sysInfoFamilies(
+				[]string{node}, []*SysInfo{info}))
+		},
+	}
+}
+
+// latestSamples - last response received from each of the agents
+type latestSamples struct {
+	mutex sync.Mutex
+	infos []*SysInfo
+	up    []bool
+}
+
+func newLatestSamples(count int) *latestSamples {
+	return &latestSamples{
+		infos: make([]*SysInfo, count),
+		up:    make([]bool, count),
+	}
+}
+
+func (ls *latestSamples) update(resp *AgentResponse) {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+
+	if resp.Index < 0 || resp.Index >= len(ls.infos) {
+		return
+	}
+	ls.up[resp.Index] = resp.Err == nil
+	if resp.Err == nil {
+		ls.infos[resp.Index] = resp.Data
+	} else {
+		// Stale values would hide the node being down in the graphs
+		ls.infos[resp.Index] = nil
+	}
+}
+
+// getMonitorMetricsEndpoint - metrics of all the nodes as last seen by the
+// monitor along with agent reachability and relay states
+func getMonitorMetricsEndpoint(
+	agents []*AgentConfig,
+	samples *latestSamples,
+	rc *RelayController) *httpx.Endpoint {
+	nodes := make([]string, len(agents))
+	for idx, agent := range agents {
+		nodes[idx] = agent.Name
+	}
+
+	return &httpx.Endpoint{
+		Method:   echo.GET,
+		Path:     "/metrics",
+		Category: "metrics",
+		Desc:     "Metrics of all the nodes in OpenMetrics text format",
+		Handler: func(etx echo.Context) error {
+			up := &metricFamily{name: "picl_agent_up",
+				help: "Whether the agent responded to the last poll"}
+
+			samples.mutex.Lock()
+			for idx, node := range nodes {
+				up.add(boolValue(samples.up[idx]), label{"node", node})
+			}
+			families := append(
+				[]*metricFamily{up}, sysInfoFamilies(nodes, samples.infos)...)
+			samples.mutex.Unlock()
+
+			if rc != nil {
+				relay := &metricFamily{name: "picl_relay_on",
+					help: "Whether the relay is switched on"}
+				if states, err := rc.GetStates(); err == nil {
+					for slot, rl := range rc.Relays() {
+						relay.add(boolValue(states[slot]),
+							label{"slot", strconv.Itoa(slot)},
+							label{"relay", rl.Name},
+							label{"node", rl.Host})
+					}
+				}
+				families = append(families, relay)
+			}
+			return sendMetrics(etx, families)
+		},
+	}
+}
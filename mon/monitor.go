@@ -9,21 +9,39 @@ import (
 	"github.com/rs/zerolog/log"
 	"github.com/varunamachi/libx/errx"
 	"github.com/varunamachi/libx/httpx"
+	"github.com/varunamachi/picl/xcutr"
 	"golang.org/x/sync/errgroup"
 )
 
+// AgentConfig - poll interval and timeout override the ones from the monitor
+// config when given
 type AgentConfig struct {
-	Name     string          `json:"name"`
-	Address  string          `json:"address"`
-	AuthData *httpx.AuthData `json:"authData"`
+	Name           string             `json:"name"`
+	Address        string             `json:"address"`
+	AuthData       *httpx.AuthData    `json:"authData"`
+	SshOpts        *xcutr.SshConnOpts `json:"sshOpts,omitempty"`
+	Mode           AgentMode          `json:"mode,omitempty"`
+	PushUser       string             `json:"pushUser,omitempty"`
+	PollIntervalMs int                `json:"pollIntervalMs,omitempty"`
+	PollTimeoutMs  int                `json:"pollTimeoutMs,omitempty"`
 }
 
 type Config struct {
-	Name        string         `json:"name"`
-	Height      int            `json:"height"`
-	Width       int            `json:"width"`
-	GoArch      string         `json:"goArch"`
-	AgentConfig []*AgentConfig `json:"agentConfig"`
+	Name            string          `json:"name"`
+	Height          int             `json:"height"`
+	Width           int             `json:"width"`
+	GoArch          string          `json:"goArch"`
+	EventLogPath    string          `json:"eventLogPath"`
+	Watchdog        *WatchdogConfig `json:"watchdog"`
+	Schedules       []*Schedule     `json:"schedules"`
+	Users           []*User         `json:"users"`
+	Insecure        bool            `json:"insecure"`
+	History         *HistoryConfig  `json:"history"`
+	Alerts          *AlertConfig    `json:"alerts"`
+	PushTimeoutSecs int             `json:"pushTimeoutSecs"`
+	PollIntervalMs  int             `json:"pollIntervalMs"`
+	PollTimeoutMs   int             `json:"pollTimeoutMs"`
+	AgentConfig     []*AgentConfig  `json:"agentConfig"`
 }
 
 func (cfg *Config) PrintSampleJSON() {
@@ -44,18 +62,40 @@ type Handler interface {
 	Close() error
 }
 
+// RelayAware - handlers that let the user switch relays get the relay
+// controller of the monitor through this. The controller is nil when relays
+// are not available
+type RelayAware interface {
+	SetRelayController(rc *RelayController)
+}
+
+// ConsoleOwner - handlers that draw on the terminal. Events of the monitor are
+// kept off the console while such a handler is in use
+type ConsoleOwner interface {
+	OwnsConsole() bool
+}
+
 type AgentResponse struct {
 	Index int
 	Data  *SysInfo
 	Err   error
+	Time  time.Time
 }
 
 type Monitor struct {
 	config   *Config
-	clients  []*httpx.Client
+	workers  []*pollWorker
 	handler  Handler
 	relayCtl *RelayController
 	server   *httpx.Server
+	events   *EventLog
+	watchdog *watchdog
+	sched    *scheduler
+	history  *history
+	samples  *latestSamples
+	alerts   *alerter
+	receiver *pushReceiver
+	stream   *StreamHandler
 }
 
 func NewMonitor(
@@ -66,104 +106,156 @@ func NewMonitor(
 	server *httpx.Server) (*Monitor, error) {
 	mon := &Monitor{
 		config:  config,
-		clients: make([]*httpx.Client, 0, len(config.AgentConfig)),
+		workers: make([]*pollWorker, 0, len(config.AgentConfig)),
 		handler: hdl,
 		server:  server,
+		events:  NewEventLog(config.EventLogPath),
+		samples: newLatestSamples(len(config.AgentConfig)),
+		stream:  NewStreamHandler(config.AgentConfig),
 	}
 
-	for _, conf := range config.AgentConfig {
-		client := httpx.NewCustomClient(
-			conf.Address, "/api/v0", httpx.DefaultTransport(),
-			100*time.Millisecond)
-		if conf.AuthData != nil {
+	if co, ok := hdl.(ConsoleOwner); ok && co.OwnsConsole() {
+		mon.events.quiet = true
+	}
 
-			if err := Login(gtx, client, *conf.AuthData); err != nil {
-				msg := "failed to login to agent"
-				log.Error().Err(err).Str("conf", conf.Name).Msg(msg)
-				return nil, errx.Errf(err, msg, conf.Name)
-			}
+	for index, conf := range config.AgentConfig {
+		// Push mode agents are not polled
+		if conf.Mode != AgentPush {
+			mon.workers = append(
+				mon.workers, newPollWorker(index, conf, config, mon.events))
+		} else if len(config.Users) == 0 {
+			log.Error().Str("node", conf.Name).Msg("push mode agents " +
+				"require users on the monitor, samples will be refused")
 		}
-		mon.clients = append(mon.clients, client)
 	}
 	var err error
 	mon.relayCtl, err = NewRelayController(realyConfig)
 	if err != nil {
-		log.Fatal().Err(err).Msg("failed to initialize GPIO, " +
+		log.Error().Err(err).Msg("failed to initialize GPIO, " +
 			"disabling related features...")
 		// return nil, err
 	}
-	mon.server.WithAPIs(getRelayEndpoints(mon.relayCtl)...)
+	if ra, ok := hdl.(RelayAware); ok {
+		ra.SetRelayController(mon.relayCtl)
+	}
+	mon.stream.SetRelayController(mon.relayCtl)
+	if len(config.Users) == 0 && config.Insecure {
+		log.Warn().Msg("no users configured for monitor, power control " +
+			"APIs are not authenticated")
+	} else if len(config.Users) == 0 {
+		log.Warn().Msg("no users configured for monitor, power control " +
+			"APIs are disabled")
+	}
+	mon.server.WithAPIs(getAuthEndpoints(config.Users)...)
+	mon.server.WithAPIs(
+		secure(config.Users, config.Insecure,
+			getRelayEndpoints(mon.relayCtl)...)...)
+	mon.server.WithAPIs(
+		secure(config.Users, config.Insecure,
+			getPowerEndpoints(mon.relayCtl)...)...)
+	mon.watchdog = newWatchdog(
+		config.Watchdog, config.AgentConfig, mon.relayCtl, mon.events)
+
+	mon.sched, err = newScheduler(
+		config.Schedules, config.AgentConfig, mon.relayCtl, mon.events)
+	if err != nil {
+		return nil, err
+	}
+	mon.server.WithAPIs(
+		secure(config.Users, config.Insecure,
+			getScheduleEndpoints(mon.sched)...)...)
+
+	mon.history, err = newHistory(config.History, config.AgentConfig)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to open history, disabling it")
+	}
+	mon.server.WithAPIs(getHistoryEndpoints(mon.history)...)
+
+	mon.alerts, err = newAlerter(config.Alerts, config.AgentConfig, mon.events)
+	if err != nil {
+		return nil, err
+	}
+	mon.server.WithAPIs(getAlertEndpoints(mon.alerts)...)
+
+	mon.receiver = newPushReceiver(
+		config.AgentConfig, config.PushTimeoutSecs, mon.history)
+	mon.server.WithAPIs(getPushEndpoints(config.Users, mon.receiver)...)
+	mon.server.WithPages(getMonitorMetricsEndpoint(
+		config.AgentConfig, mon.samples, mon.relayCtl))
+	mon.server.WithAPIs(getStreamEndpoints(mon.stream)...)
+	mon.server.WithPages(getDashboardPages()...)
 	return mon, nil
 }
 
 func (mon *Monitor) Run(
 	gtx context.Context, port uint32) error {
 
-	out := make(chan *AgentResponse)
+	// Not closed, workers may still be sending when the consumer returns
+	out := make(chan *AgentResponse, len(mon.workers))
 	defer func() {
-		close(out)
 		if mon.relayCtl != nil {
 			mon.relayCtl.Close()
 		}
+		mon.history.close()
+		mon.stream.Close()
 	}()
-	eg := errgroup.Group{}
+	// Any of the goroutines failing stops the others, the server included
+	eg, gtx := errgroup.WithContext(gtx)
 
-	eg.Go(func() error {
-		return mon.poll(gtx, &eg, out)
-	})
+	for _, worker := range mon.workers {
+		worker := worker
+		eg.Go(func() error {
+			worker.run(gtx, out)
+			return nil
+		})
+	}
 
 	eg.Go(func() error {
 		for {
+			var resp *AgentResponse
 			select {
 			case <-gtx.Done():
-				mon.server.Close()
 				return gtx.Err()
-			case resp := <-out:
-				if err := mon.handler.Handle(gtx, resp); err != nil {
-					return errx.Wrap(err)
-				}
+			case resp = <-out:
+			case resp = <-mon.receiver.out:
+			}
+
+			mon.watchdog.observe(resp)
+			mon.history.record(resp)
+			mon.samples.update(resp)
+			mon.alerts.observe(resp)
+			if err := mon.stream.Handle(gtx, resp); err != nil {
+				return errx.Wrap(err)
+			}
+			if err := mon.handler.Handle(gtx, resp); err != nil {
+				return errx.Wrap(err)
 			}
 		}
 	})
+	eg.Go(func() error {
+		return mon.sched.run(gtx)
+	})
+	eg.Go(func() error {
+		return mon.watchdog.run(gtx)
+	})
+	eg.Go(func() error {
+		return mon.alerts.run(gtx)
+	})
+	eg.Go(func() error {
+		return mon.receiver.watch(gtx)
+	})
 	eg.Go(func() error {
 		return mon.server.Start(port)
 	})
+	eg.Go(func() error {
+		<-gtx.Done()
+		return mon.server.Close()
+	})
 
 	return eg.Wait()
 
 }
 
-func (mon *Monitor) poll(
-	gtx context.Context,
-	eg *errgroup.Group,
-	dataOut chan<- *AgentResponse) error {
-
-	for {
-		select {
-		case <-gtx.Done():
-			return gtx.Err()
-		default:
-			// No-op
-
-			for index, client := range mon.clients {
-				index := index
-				client := client
-				eg.Go(func() error {
-					info := &SysInfo{}
-					res := client.Get(gtx, "/cur")
-					if err := res.LoadClose(&info); err != nil {
-						dataOut <- &AgentResponse{Index: index, Err: err}
-						return errx.Wrap(err)
-					}
-					dataOut <- &AgentResponse{Index: index, Data: info}
-					return nil
-				})
-			}
-		}
-		time.Sleep(1 * time.Second)
-	}
-}
-
 type simpleHandler struct {
 	monConfig *Config
 }
@@ -183,12 +275,29 @@ func (sh *simpleHandler) Handle(
 		return nil
 	}
 
-	fmt.Printf("%2d.  %10s   Tmp: %4.2f   CPU: %4.2f%%   Mem: %4.2f%%\n",
+	info := resp.Data
+	fmt.Printf("%2d.  %10s   Tmp: %5s   CPU: %4.2f%%   Mem: %4.2f%%",
 		resp.Index,
 		node.Name,
-		resp.Data.CPUTemp/1000,
-		resp.Data.CPUUsagePct,
-		resp.Data.MemUsagePct)
+		info.FormatTemp(),
+		info.CPUUsagePct,
+		info.MemUsagePct)
+	if info.Version >= 1 {
+		rx, tx := info.NetTotals()
+		fmt.Printf("   Swap: %4.2f%%   Disk: %4.2f%%   Net: %s/%s",
+			info.SwapUsagePct,
+			info.MaxDiskPct(),
+			humanRate(rx),
+			humanRate(tx))
+		if info.Load != nil {
+			fmt.Printf("   Load: %.2f %.2f %.2f",
+				info.Load.Load1, info.Load.Load5, info.Load.Load15)
+		}
+		if flags := info.Throttling.Flags(); flags != "" {
+			fmt.Printf("   Throttling: %s", flags)
+		}
+	}
+	fmt.Println()
 	return nil
 }
 
@@ -0,0 +1,236 @@
+package mon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/varunamachi/libx/errx"
+)
+
+var (
+	ErrInvalidNotifier = errors.New("mon.alert.invalidNotifier")
+	ErrNotifyFailed    = errors.New("mon.alert.notifyFailed")
+)
+
+// Notifier - delivers alerts to someone or something
+type Notifier interface {
+	Notify(gtx context.Context, alert *Alert) error
+}
+
+type NotifierType string
+
+const (
+	NotifyStdout  NotifierType = "stdout"
+	NotifyWebhook NotifierType = "webhook"
+	NotifyEmail   NotifierType = "email"
+	NotifyCommand NotifierType = "command"
+)
+
+// NotifierConfig - settings of a notifier, only the ones relevant to the type
+// are used
+type NotifierConfig struct {
+	Name string       `json:"name"`
+	Type NotifierType `json:"type"`
+
+	// Webhook
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Email
+	Smtp *SmtpConfig `json:"smtp,omitempty"`
+
+	// Command
+	Command string   `json:"command,omitempty"`
+	Args    []string `json:"args,omitempty"`
+}
+
+type SmtpConfig struct {
+	Host     string   `json:"host"`
+	Port     int      `json:"port"`
+	UserName string   `json:"userName,omitempty"`
+	Password string   `json:"password,omitempty"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+}
+
+func NewNotifier(cfg *NotifierConfig) (Notifier, error) {
+	if cfg.Name == "" {
+		return nil, errx.Errf(ErrInvalidNotifier,
+			"notifier of type '%s' does not have a name", cfg.Type)
+	}
+
+	switch cfg.Type {
+	case NotifyStdout:
+		return &StdoutNotifier{Out: os.Stdout}, nil
+	case NotifyWebhook:
+		if cfg.URL == "" {
+			return nil, errx.Errf(ErrInvalidNotifier,
+				"webhook notifier '%s' does not have an URL", cfg.Name)
+		}
+		return &WebhookNotifier{
+			URL:     cfg.URL,
+			Headers: cfg.Headers,
+			client:  &http.Client{},
+		}, nil
+	case NotifyEmail:
+		if cfg.Smtp == nil || cfg.Smtp.Host == "" ||
+			cfg.Smtp.From == "" || len(cfg.Smtp.To) == 0 {
+			return nil, errx.Errf(ErrInvalidNotifier,
+				"email notifier '%s' requires SMTP host, from and to",
+				cfg.Name)
+		}
+		return &EmailNotifier{Smtp: *cfg.Smtp}, nil
+	case NotifyCommand:
+		if cfg.Command == "" {
+			return nil, errx.Errf(ErrInvalidNotifier,
+				"command notifier '%s' does not have a command", cfg.Name)
+		}
+		return &CommandNotifier{Command: cfg.Command, Args: cfg.Args}, nil
+	}
+	return nil, errx.Errf(ErrInvalidNotifier,
+		"invalid type '%s' for notifier '%s', should be one of: "+
+			"stdout, webhook, email, command", cfg.Type, cfg.Name)
+}
+
+// StdoutNotifier - prints a line per alert
+type StdoutNotifier struct {
+	Out io.Writer
+}
+
+func (sn *StdoutNotifier) Notify(_ context.Context, alert *Alert) error {
+	_, err := fmt.Fprintln(sn.Out, alert.Time.Format(time.RFC3339),
+		alert.Summary())
+	return errx.Wrap(err)
+}
+
+// WebhookNotifier - POSTs the alert as JSON to the URL
+type WebhookNotifier struct {
+	URL     string
+	Headers map[string]string
+	client  *http.Client
+}
+
+func (wn *WebhookNotifier) Notify(gtx context.Context, alert *Alert) error {
+	data, err := json.Marshal(alert)
+	if err != nil {
+		return errx.Wrap(err)
+	}
+	req, err := http.NewRequestWithContext(
+		gtx, http.MethodPost, wn.URL, bytes.NewReader(data))
+	if err != nil {
+		return errx.Errf(err, "failed to create webhook request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, val := range wn.Headers {
+		req.Header.Set(key, val)
+	}
+
+	resp, err := wn.client.Do(req)
+	if err != nil {
+		return errx.Errf(err, "failed to call webhook '%s'", wn.URL)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errx.Errf(ErrNotifyFailed,
+			"webhook '%s' responded with status %d", wn.URL, resp.StatusCode)
+	}
+	return nil
+}
+
+// EmailNotifier - sends a mail per alert, logs in only if a user name is given
+type EmailNotifier struct {
+	Smtp SmtpConfig
+}
+
+func (en *EmailNotifier) Notify(gtx context.Context, alert *Alert) error {
+	port := en.Smtp.Port
+	if port == 0 {
+		port = 25
+	}
+	address := net.JoinHostPort(en.Smtp.Host, strconv.Itoa(port))
+
+	var auth smtp.Auth
+	if en.Smtp.UserName != "" {
+		auth = smtp.PlainAuth(
+			"", en.Smtp.UserName, en.Smtp.Password, en.Smtp.Host)
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", en.Smtp.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(en.Smtp.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", alert.Summary())
+	fmt.Fprintf(&msg, "Date: %s\r\n", alert.Time.Format(time.RFC1123Z))
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&msg, "Rule:  %s\r\n", alert.Rule)
+	fmt.Fprintf(&msg, "Expr:  %s\r\n", alert.Expr)
+	fmt.Fprintf(&msg, "Node:  %s\r\n", alert.Node)
+	fmt.Fprintf(&msg, "State: %s\r\n", alert.State)
+	if alert.Value != nil {
+		fmt.Fprintf(&msg, "Value: %.2f\r\n", *alert.Value)
+	}
+	fmt.Fprintf(&msg, "Since: %s\r\n", alert.Since.Format(time.RFC3339))
+
+	// smtp.SendMail does not take a context, so it is raced against it
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(
+			address, auth, en.Smtp.From, en.Smtp.To, []byte(msg.String()))
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return errx.Errf(err, "failed to send alert mail via '%s'",
+				address)
+		}
+		return nil
+	case <-gtx.Done():
+		return errx.Errf(gtx.Err(), "timed out sending alert mail via '%s'",
+			address)
+	}
+}
+
+// CommandNotifier - runs a local command per alert with the alert as JSON on
+// its stdin and the main fields in PICL_ALERT_* environment variables
+type CommandNotifier struct {
+	Command string
+	Args    []string
+}
+
+func (cn *CommandNotifier) Notify(gtx context.Context, alert *Alert) error {
+	data, err := json.Marshal(alert)
+	if err != nil {
+		return errx.Wrap(err)
+	}
+
+	cmd := exec.CommandContext(gtx, cn.Command, cn.Args...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Env = append(os.Environ(),
+		"PICL_ALERT_RULE="+alert.Rule,
+		"PICL_ALERT_NODE="+alert.Node,
+		"PICL_ALERT_STATE="+string(alert.State),
+		"PICL_ALERT_SUMMARY="+alert.Summary(),
+	)
+	if alert.Value != nil {
+		cmd.Env = append(cmd.Env,
+			"PICL_ALERT_VALUE="+strconv.FormatFloat(*alert.Value, 'f', -1, 64))
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return errx.Errf(err, "alert command '%s' failed: %s",
+			cn.Command, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,339 @@
+package mon
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/varunamachi/libx/errx"
+)
+
+func testAlert() *Alert {
+	val := 81.5
+	now := time.Now().Truncate(time.Second)
+	return &Alert{
+		Rule:  "hot",
+		Expr:  "cpuTemp > 75",
+		Node:  "pi1",
+		State: AlertFiring,
+		Value: &val,
+		Since: now.Add(-time.Minute),
+		Time:  now,
+	}
+}
+
+func TestNewNotifierValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  NotifierConfig
+	}{
+		{"no name", NotifierConfig{Type: NotifyStdout}},
+		{"unknown type", NotifierConfig{Name: "x", Type: "pager"}},
+		{"webhook without url", NotifierConfig{Name: "x", Type: NotifyWebhook}},
+		{"email without smtp", NotifierConfig{Name: "x", Type: NotifyEmail}},
+		{"email without to", NotifierConfig{
+			Name: "x",
+			Type: NotifyEmail,
+			Smtp: &SmtpConfig{Host: "localhost", From: "a@b"},
+		}},
+		{"command without command", NotifierConfig{
+			Name: "x",
+			Type: NotifyCommand,
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewNotifier(&test.cfg)
+			if !errors.Is(err, ErrInvalidNotifier) {
+				t.Fatalf("expected ErrInvalidNotifier, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	var got Alert
+	var header string
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			header = r.Header.Get("X-Token")
+			if r.Method != http.MethodPost ||
+				r.Header.Get("Content-Type") != "application/json" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}))
+	defer srv.Close()
+
+	notifier, err := NewNotifier(&NotifierConfig{
+		Name:    "hook",
+		Type:    NotifyWebhook,
+		URL:     srv.URL,
+		Headers: map[string]string{"X-Token": "secret"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	alert := testAlert()
+	if err := notifier.Notify(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+	if header != "secret" {
+		t.Errorf("expected the configured header, got '%s'", header)
+	}
+	if got.Rule != alert.Rule || got.Node != alert.Node ||
+		got.State != alert.State || got.Value == nil ||
+		*got.Value != *alert.Value {
+		t.Errorf("webhook received %+v, expected %+v", got, *alert)
+	}
+}
+
+func TestWebhookNotifierFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+	defer srv.Close()
+
+	notifier := &WebhookNotifier{URL: srv.URL, client: srv.Client()}
+	err := notifier.Notify(context.Background(), testAlert())
+	if !errors.Is(err, ErrNotifyFailed) {
+		t.Fatalf("expected ErrNotifyFailed, got: %v", err)
+	}
+}
+
+// smtpMail - what the fake SMTP server received
+type smtpMail struct {
+	from string
+	to   []string
+	data string
+}
+
+// fakeSmtp - accepts a single mail without authentication or TLS and sends it
+// on the channel
+func fakeSmtp(t *testing.T) (string, int, <-chan *smtpMail) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	mails := make(chan *smtpMail, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		rd := bufio.NewReader(conn)
+		reply := func(line string) {
+			io.WriteString(conn, line+"\r\n")
+		}
+		mail := &smtpMail{}
+		reply("220 localhost ESMTP")
+		for {
+			line, err := rd.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			cmd := strings.ToUpper(line)
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"),
+				strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(cmd, "MAIL FROM:"):
+				mail.from = strings.Trim(line[len("MAIL FROM:"):], "<>")
+				reply("250 OK")
+			case strings.HasPrefix(cmd, "RCPT TO:"):
+				mail.to = append(mail.to,
+					strings.Trim(line[len("RCPT TO:"):], "<>"))
+				reply("250 OK")
+			case cmd == "DATA":
+				reply("354 end with <CRLF>.<CRLF>")
+				var data strings.Builder
+				for {
+					dl, err := rd.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if dl == ".\r\n" {
+						break
+					}
+					data.WriteString(dl)
+				}
+				mail.data = data.String()
+				reply("250 OK")
+				mails <- mail
+			case cmd == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("502 not implemented")
+			}
+		}
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, mails
+}
+
+func TestEmailNotifier(t *testing.T) {
+	host, port, mails := fakeSmtp(t)
+	notifier, err := NewNotifier(&NotifierConfig{
+		Name: "mail",
+		Type: NotifyEmail,
+		Smtp: &SmtpConfig{
+			Host: host,
+			Port: port,
+			From: "picl@example.com",
+			To:   []string{"ops@example.com", "admin@example.com"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alert := testAlert()
+	if err := notifier.Notify(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+
+	var mail *smtpMail
+	select {
+	case mail = <-mails:
+	case <-time.After(5 * time.Second):
+		t.Fatal("fake SMTP server did not receive the mail")
+	}
+	if mail.from != "picl@example.com" {
+		t.Errorf("unexpected sender '%s'", mail.from)
+	}
+	if strings.Join(mail.to, ",") != "ops@example.com,admin@example.com" {
+		t.Errorf("unexpected recipients %v", mail.to)
+	}
+	for _, want := range []string{
+		"Subject: " + alert.Summary() + "\r\n",
+		"Node:  pi1\r\n",
+		"State: firing\r\n",
+		"Value: 81.50\r\n",
+	} {
+		if !strings.Contains(mail.data, want) {
+			t.Errorf("mail does not contain %q:\n%s", want, mail.data)
+		}
+	}
+}
+
+func TestEmailNotifierTimeout(t *testing.T) {
+	// Server that accepts connections but never greets
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conns := []net.Conn{}
+		defer func() {
+			for _, conn := range conns {
+				conn.Close()
+			}
+		}()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	notifier := &EmailNotifier{Smtp: SmtpConfig{
+		Host: addr.IP.String(),
+		Port: addr.Port,
+		From: "picl@example.com",
+		To:   []string{"ops@example.com"},
+	}}
+	gtx, cancel := context.WithTimeout(
+		context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = notifier.Notify(gtx, testAlert())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got: %v", err)
+	}
+}
+
+func TestCommandNotifier(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "alert.json")
+	env := filepath.Join(dir, "env")
+
+	notifier, err := NewNotifier(&NotifierConfig{
+		Name:    "cmd",
+		Type:    NotifyCommand,
+		Command: "sh",
+		Args: []string{"-c",
+			`cat > "$0" && ` +
+				`printf '%s|%s|%s|%s' "$PICL_ALERT_RULE" "$PICL_ALERT_NODE" ` +
+				`"$PICL_ALERT_STATE" "$PICL_ALERT_VALUE" > "$1"`,
+			out, env},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	alert := testAlert()
+	if err := notifier.Notify(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Alert
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("command did not get the alert as JSON: %v", err)
+	}
+	if got.Rule != alert.Rule || got.Node != alert.Node {
+		t.Errorf("command received %+v, expected %+v", got, *alert)
+	}
+
+	data, err = os.ReadFile(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "hot|pi1|firing|" + strconv.FormatFloat(*alert.Value, 'f', -1, 64)
+	if string(data) != want {
+		t.Errorf("expected environment '%s', got '%s'", want, data)
+	}
+}
+
+func TestCommandNotifierFailure(t *testing.T) {
+	notifier := &CommandNotifier{
+		Command: "sh",
+		Args:    []string{"-c", "echo broken pipe; exit 3"},
+	}
+	err := notifier.Notify(context.Background(), testAlert())
+	var ex *errx.Error
+	if !errors.As(err, &ex) || !strings.Contains(ex.Msg, "broken pipe") {
+		t.Fatalf("expected failure with the command output, got: %v", err)
+	}
+}
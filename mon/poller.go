@@ -0,0 +1,145 @@
+package mon
+
+import (
+	"context"
+	"time"
+
+	"github.com/varunamachi/libx/httpx"
+)
+
+const (
+	defaultPollInterval = 1 * time.Second
+	defaultPollTimeout  = 2 * time.Second
+	maxPollBackoff      = 1 * time.Minute
+)
+
+type AgentState string
+
+const (
+	AgentUnknown AgentState = ""
+	AgentUp      AgentState = "up"
+	AgentDown    AgentState = "down"
+)
+
+// pollWorker - polls a single agent on its own schedule, so that a slow or
+// dead agent does not hold up the others. Failed polls are retried with
+// exponential backoff up to maxPollBackoff, the client is recreated on each
+// retry so that the worker logs in again once the agent is back
+type pollWorker struct {
+	index    int
+	conf     *AgentConfig
+	interval time.Duration
+	timeout  time.Duration
+	events   *EventLog
+	client   *httpx.Client
+	failures int
+	state    AgentState
+}
+
+// newPollWorker - interval and timeout of the agent take precedence over the
+// ones of the monitor, defaults are used when neither is given
+func newPollWorker(
+	index int,
+	conf *AgentConfig,
+	monConf *Config,
+	events *EventLog) *pollWorker {
+	return &pollWorker{
+		index:  index,
+		conf:   conf,
+		events: events,
+		interval: millisOr(defaultPollInterval,
+			conf.PollIntervalMs, monConf.PollIntervalMs),
+		timeout: millisOr(defaultPollTimeout,
+			conf.PollTimeoutMs, monConf.PollTimeoutMs),
+	}
+}
+
+// millisOr - first positive value as milliseconds, the default otherwise
+func millisOr(def time.Duration, values ...int) time.Duration {
+	for _, val := range values {
+		if val > 0 {
+			return time.Duration(val) * time.Millisecond
+		}
+	}
+	return def
+}
+
+// run - polls the agent until the context is done. Every poll results in a
+// response on the channel, failures included
+func (pw *pollWorker) run(gtx context.Context, out chan<- *AgentResponse) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-gtx.Done():
+			return
+		case <-timer.C:
+		}
+
+		start := time.Now()
+		resp := pw.poll(gtx)
+		pw.update(resp.Err)
+		select {
+		case out <- resp:
+		case <-gtx.Done():
+			return
+		}
+		timer.Reset(max(pw.delay()-time.Since(start), 0))
+	}
+}
+
+func (pw *pollWorker) poll(gtx context.Context) *AgentResponse {
+	resp := &AgentResponse{Index: pw.index, Time: time.Now()}
+
+	gtx, cancel := context.WithTimeout(gtx, pw.timeout)
+	defer cancel()
+
+	if pw.client == nil {
+		client, err := NewAgentClient(gtx, pw.conf, pw.timeout)
+		if err != nil {
+			resp.Err = err
+			return resp
+		}
+		pw.client = client
+	}
+
+	info := &SysInfo{}
+	if err := pw.client.Get(gtx, "/api/v0/cur").LoadClose(info); err != nil {
+		// The agent may have restarted with a different key, login again
+		pw.client = nil
+		resp.Err = err
+		return resp
+	}
+	resp.Data = info
+	return resp
+}
+
+// update - tracks failures and records changes in reachability of the agent
+func (pw *pollWorker) update(err error) {
+	if err == nil {
+		pw.failures = 0
+		if pw.state != AgentUp {
+			if pw.state == AgentDown {
+				pw.events.Add(EventAgent, pw.conf.Name, "agent is up again")
+			}
+			pw.state = AgentUp
+		}
+		return
+	}
+
+	pw.failures++
+	if pw.state != AgentDown {
+		pw.state = AgentDown
+		pw.events.Add(EventAgent, pw.conf.Name,
+			"agent is unreachable, retrying with backoff: %v", err)
+	}
+}
+
+// delay - time until the next poll, doubles with every consecutive failure
+func (pw *pollWorker) delay() time.Duration {
+	delay := pw.interval
+	for idx := 1; idx < pw.failures && delay < maxPollBackoff; idx++ {
+		delay *= 2
+	}
+	return min(delay, max(maxPollBackoff, pw.interval))
+}
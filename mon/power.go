@@ -0,0 +1,378 @@
+package mon
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+	"github.com/varunamachi/libx/errx"
+	"github.com/varunamachi/libx/httpx"
+	"github.com/varunamachi/picl/xcutr"
+)
+
+var (
+	ErrRelayNotFound      = errors.New("mon.relay.notFound")
+	ErrInvalidPowerAction = errors.New("mon.power.invalidAction")
+	ErrShutdownTimeout    = errors.New("mon.power.shutdownTimeout")
+)
+
+type PowerAction string
+
+const (
+	PowerOn    PowerAction = "on"
+	PowerOff   PowerAction = "off"
+	PowerCycle PowerAction = "cycle"
+)
+
+const (
+	DefaultCycleDelay = 5 * time.Second
+
+	// MaxCycleDelay - upper limit for the delay of a power cycle requested
+	// through the API
+	MaxCycleDelay = 5 * time.Minute
+
+	// Time given to a node to finish shutting down after it stops accepting
+	// SSH connections and before the power is cut
+	shutdownGrace = 5 * time.Second
+
+	// Time allowed for connecting and logging in to a node over SSH
+	sshDialTimeout = 10 * time.Second
+)
+
+func ToPowerAction(action string) (PowerAction, error) {
+	switch pa := PowerAction(strings.ToLower(action)); pa {
+	case PowerOn, PowerOff, PowerCycle:
+		return pa, nil
+	}
+	return "", errx.Errf(ErrInvalidPowerAction,
+		"invalid power action '%s', should be one of: on | off | cycle",
+		action)
+}
+
+// PowerStatus - state of a relay along with the node it powers
+type PowerStatus struct {
+	Slot  int    `json:"slot"`
+	Relay string `json:"relay"`
+	Host  string `json:"host"`
+	On    bool   `json:"on"`
+}
+
+type PowerRequest struct {
+	// Delay between turning off and on while power cycling, as a duration
+	// string, e.g. 5s
+	Delay string `json:"delay"`
+}
+
+// SlotOf - gives the slot of the relay that powers the given node. Name of
+// the relay itself is also accepted
+func (rc *RelayController) SlotOf(name string) (int, error) {
+	for slot, relay := range rc.relays {
+		if relay.Host == name {
+			return slot, nil
+		}
+	}
+	for slot, relay := range rc.relays {
+		if relay.Name == name {
+			return slot, nil
+		}
+	}
+	return -1, errx.Errf(ErrRelayNotFound,
+		"could not find relay for node '%s'", name)
+}
+
+// Cycle - turns the relay at given slot off and turns it back on after the
+// delay, unless the relay was switched by someone else in the meantime
+func (rc *RelayController) Cycle(slot int, delay time.Duration) error {
+	change, err := rc.cycleOff(slot)
+	if err != nil {
+		return err
+	}
+	time.Sleep(delay)
+	return rc.cycleOn(slot, change)
+}
+
+// startCycle - turns the relay at given slot off and turns it back on after
+// the delay in the background. Failures to turn it on are only logged
+func (rc *RelayController) startCycle(slot int, delay time.Duration) error {
+	change, err := rc.cycleOff(slot)
+	if err != nil {
+		return err
+	}
+	go func() {
+		time.Sleep(delay)
+		if err := rc.cycleOn(slot, change); err != nil {
+			log.Error().Err(err).Int("slot", slot).
+				Msg("failed to turn relay back on while power cycling")
+		}
+	}()
+	return nil
+}
+
+// cycleOff - turns the relay off and gives the change it made, so that
+// cycleOn can tell whether the relay was switched since
+func (rc *RelayController) cycleOff(slot int) (uint64, error) {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	if err := rc.check(slot); err != nil {
+		return 0, err
+	}
+	if err := rc.write(slot, false); err != nil {
+		return 0, err
+	}
+	rc.persist()
+	return rc.changes[slot], nil
+}
+
+// cycleOn - turns the relay back on if it was not switched after cycleOff.
+// A relay turned off or on by others in the meantime is left as it is
+func (rc *RelayController) cycleOn(slot int, change uint64) error {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	if rc.changes[slot] != change {
+		log.Info().Str("relay", rc.relays[slot].Name).
+			Msg("relay was switched during power cycle, not turning it on")
+		return nil
+	}
+	if err := rc.write(slot, true); err != nil {
+		return err
+	}
+	rc.persist()
+	return nil
+}
+
+func (rc *RelayController) PowerStatuses() ([]*PowerStatus, error) {
+	states, err := rc.GetStates()
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]*PowerStatus, len(rc.relays))
+	for slot, relay := range rc.relays {
+		statuses[slot] = &PowerStatus{
+			Slot:  slot,
+			Relay: relay.Name,
+			Host:  relay.Host,
+			On:    states[slot],
+		}
+	}
+	return statuses, nil
+}
+
+func getPowerEndpoints(rc *RelayController) []*httpx.Endpoint {
+	return []*httpx.Endpoint{
+		{
+			Method:   echo.GET,
+			Path:     "/power",
+			Category: "power",
+			Desc:     "Get power state of all the relays and their nodes",
+			Version:  "v1",
+			Handler: func(etx echo.Context) error {
+				if rc == nil {
+					return &echo.HTTPError{
+						Message: "gpio features not enabled",
+						Code:    http.StatusInternalServerError,
+					}
+				}
+
+				statuses, err := rc.PowerStatuses()
+				if err != nil {
+					return &echo.HTTPError{
+						Message:  "failed to get power states",
+						Code:     http.StatusInternalServerError,
+						Internal: err,
+					}
+				}
+				return httpx.SendJSON(etx, statuses)
+			},
+		},
+		{
+			Method:   echo.POST,
+			Path:     "/power/:node/:action",
+			Category: "power",
+			Desc:     "Turn a node on/off or power cycle it",
+			Version:  "v1",
+			Handler: func(etx echo.Context) error {
+				if rc == nil {
+					return &echo.HTTPError{
+						Message: "gpio features not enabled",
+						Code:    http.StatusInternalServerError,
+					}
+				}
+
+				slot, err := rc.SlotOf(etx.Param("node"))
+				if err != nil {
+					return &echo.HTTPError{
+						Message:  "no relay found for the node",
+						Code:     http.StatusNotFound,
+						Internal: err,
+					}
+				}
+
+				action, err := ToPowerAction(etx.Param("action"))
+				if err != nil {
+					return &echo.HTTPError{
+						Message:  "invalid power action",
+						Code:     http.StatusBadRequest,
+						Internal: err,
+					}
+				}
+
+				var req PowerRequest
+				if etx.Request().ContentLength > 0 {
+					if err := etx.Bind(&req); err != nil {
+						return &echo.HTTPError{
+							Message:  "invalid power request",
+							Code:     http.StatusBadRequest,
+							Internal: err,
+						}
+					}
+				}
+
+				code := http.StatusOK
+				switch action {
+				case PowerOn:
+					err = rc.SetState(slot, true)
+				case PowerOff:
+					err = rc.SetState(slot, false)
+				case PowerCycle:
+					delay := DefaultCycleDelay
+					if req.Delay != "" {
+						delay, err = time.ParseDuration(req.Delay)
+						if err != nil || delay <= 0 || delay > MaxCycleDelay {
+							return &echo.HTTPError{
+								Message: fmt.Sprintf(
+									"delay should be a duration up to %s",
+									MaxCycleDelay),
+								Code:     http.StatusBadRequest,
+								Internal: err,
+							}
+						}
+					}
+					// Power goes back on in the background, the request
+					// should not outlive the client timeouts
+					code = http.StatusAccepted
+					err = rc.startCycle(slot, delay)
+				}
+				if err != nil {
+					return &echo.HTTPError{
+						Message:  "failed to set power state",
+						Code:     http.StatusInternalServerError,
+						Internal: err,
+					}
+				}
+
+				statuses, err := rc.PowerStatuses()
+				if err != nil {
+					return &echo.HTTPError{
+						Message:  "failed to get power states",
+						Code:     http.StatusInternalServerError,
+						Internal: err,
+					}
+				}
+				return etx.JSON(code, statuses[slot])
+			},
+		},
+	}
+}
+
+// GetPowerStatuses - gets power state of all the relays from the monitor
+func GetPowerStatuses(
+	gtx context.Context, client *httpx.Client) ([]*PowerStatus, error) {
+	statuses := make([]*PowerStatus, 0, 10)
+	res := client.Get(gtx, "/api/v1/power")
+	if err := res.LoadClose(&statuses); err != nil {
+		return nil, errx.Errf(err, "failed to get power status from monitor")
+	}
+	return statuses, nil
+}
+
+// SetPower - asks the monitor to perform given power action on the node
+func SetPower(
+	gtx context.Context,
+	client *httpx.Client,
+	node string,
+	action PowerAction,
+	delay time.Duration) (*PowerStatus, error) {
+
+	req := PowerRequest{}
+	if delay != 0 {
+		req.Delay = delay.String()
+	}
+
+	status := &PowerStatus{}
+	res := client.Post(gtx, &req, "/api/v1/power", node, string(action))
+	if err := res.LoadClose(status); err != nil {
+		return nil, errx.Errf(err,
+			"failed to perform power action '%s' on node '%s'", action, node)
+	}
+	return status, nil
+}
+
+// ShutdownNode - shuts the node down over SSH and waits till it stops
+// accepting SSH connections. Nothing is done if the node is not reachable
+func ShutdownNode(opts *xcutr.SshConnOpts, timeout time.Duration) error {
+	// Defaults go into a copy, the options are shared with the config
+	copied := *opts
+	opts = &copied
+	opts.FillDefaults()
+	address := net.JoinHostPort(opts.Host, fmt.Sprint(opts.Port))
+	if !isReachable(address) {
+		log.Info().Str("node", opts.Name).Msg("node is already unreachable")
+		return nil
+	}
+
+	// Dialled without the fatal paths of the CLI helpers, an unreachable node
+	// should not take the monitor down
+	conn, err := xcutr.DialConn(opts, sshDialTimeout)
+	if err != nil {
+		return errx.Errf(err, "failed to connect to node '%s'", opts.Name)
+	}
+	defer conn.Close()
+
+	log.Info().Str("node", opts.Name).Msg("shutting node down")
+	var output bytes.Buffer
+	err = conn.ExecSudo("shutdown -h now", &xcutr.StdIO{
+		Out: &output,
+		Err: &output,
+	})
+	if err != nil {
+		// The connection usually drops before the command exits
+		log.Debug().Err(err).Str("node", opts.Name).
+			Str("output", strings.TrimSpace(output.String())).
+			Msg("shutdown command")
+	}
+
+	return WaitUntilDown(opts.Name, address, timeout)
+}
+
+// WaitUntilDown - waits until given address stops accepting TCP connections
+func WaitUntilDown(name, address string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if !isReachable(address) {
+			log.Info().Str("node", name).Msg("node is down")
+			time.Sleep(shutdownGrace)
+			return nil
+		}
+		time.Sleep(2 * time.Second)
+	}
+	return errx.Errf(ErrShutdownTimeout,
+		"node '%s' did not go down within %s", name, timeout)
+}
+
+func isReachable(address string) bool {
+	conn, err := net.DialTimeout("tcp", address, time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
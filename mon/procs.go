@@ -0,0 +1,160 @@
+package mon
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shirou/gopsutil/v3/process"
+	"github.com/varunamachi/libx/errx"
+	"github.com/varunamachi/libx/httpx"
+)
+
+const (
+	defaultTopProcs = 10
+	maxTopProcs     = 50
+
+	// CPU usage of processes is measured over this interval, like top does
+	// between refreshes
+	procSampleInterval = 500 * time.Millisecond
+)
+
+// ProcInfo - resource usage of a process. CPU usage is relative to a single
+// core, so a process using two cores fully has 200%
+type ProcInfo struct {
+	Pid         int32   `json:"pid"`
+	User        string  `json:"user"`
+	Name        string  `json:"name"`
+	Cmdline     string  `json:"cmdline"`
+	CPUUsagePct float64 `json:"cpuUsage"`
+	MemUsagePct float32 `json:"memUsage"`
+	RSS         uint64  `json:"rss"`
+	RuntimeSecs int64   `json:"runtimeSecs"`
+}
+
+// TopProcs - processes using most CPU and most memory
+type TopProcs struct {
+	ByCPU []*ProcInfo `json:"byCpu"`
+	ByMem []*ProcInfo `json:"byMem"`
+}
+
+func cpuSeconds(gtx context.Context, proc *process.Process) (float64, bool) {
+	times, err := proc.TimesWithContext(gtx)
+	if err != nil {
+		return 0, false
+	}
+	return times.User + times.System, true
+}
+
+// topProcesses - samples CPU times of all the processes twice, so that CPU
+// usage reflects the current load rather than the average since start
+func topProcesses(gtx context.Context, count int) (*TopProcs, error) {
+	procs, err := process.ProcessesWithContext(gtx)
+	if err != nil {
+		return nil, errx.Errf(err, "failed to list processes")
+	}
+
+	before := make(map[int32]float64, len(procs))
+	for _, proc := range procs {
+		if secs, ok := cpuSeconds(gtx, proc); ok {
+			before[proc.Pid] = secs
+		}
+	}
+
+	select {
+	case <-gtx.Done():
+		return nil, gtx.Err()
+	case <-time.After(procSampleInterval):
+	}
+
+	now := time.Now()
+	infos := make([]*ProcInfo, 0, len(procs))
+	for _, proc := range procs {
+		prev, found := before[proc.Pid]
+		secs, ok := cpuSeconds(gtx, proc)
+		if !found || !ok {
+			// Process exited in between or is not accessible
+			continue
+		}
+
+		info := &ProcInfo{
+			Pid: proc.Pid,
+			CPUUsagePct: (secs - prev) /
+				procSampleInterval.Seconds() * 100,
+		}
+		info.Name, _ = proc.NameWithContext(gtx)
+		info.User, _ = proc.UsernameWithContext(gtx)
+		info.Cmdline, _ = proc.CmdlineWithContext(gtx)
+		info.MemUsagePct, _ = proc.MemoryPercentWithContext(gtx)
+		if mem, err := proc.MemoryInfoWithContext(gtx); err == nil {
+			info.RSS = mem.RSS
+		}
+		if created, err := proc.CreateTimeWithContext(gtx); err == nil {
+			runtime := now.Sub(time.UnixMilli(created))
+			info.RuntimeSecs = int64(runtime.Seconds())
+		}
+		infos = append(infos, info)
+	}
+
+	top := &TopProcs{}
+	slices.SortFunc(infos, func(a, b *ProcInfo) int {
+		return compareDesc(a.CPUUsagePct, b.CPUUsagePct)
+	})
+	top.ByCPU = slices.Clone(infos[:min(count, len(infos))])
+
+	slices.SortFunc(infos, func(a, b *ProcInfo) int {
+		return compareDesc(float64(a.MemUsagePct), float64(b.MemUsagePct))
+	})
+	top.ByMem = slices.Clone(infos[:min(count, len(infos))])
+	return top, nil
+}
+
+func compareDesc(a, b float64) int {
+	switch {
+	case a > b:
+		return -1
+	case a < b:
+		return 1
+	}
+	return 0
+}
+
+func handleProcs(etx echo.Context) error {
+	count := defaultTopProcs
+	if countStr := etx.QueryParam("n"); countStr != "" {
+		var err error
+		count, err = strconv.Atoi(countStr)
+		if err != nil || count <= 0 || count > maxTopProcs {
+			return &echo.HTTPError{
+				Code:     http.StatusBadRequest,
+				Message:  "n should be a number between 1 and 50",
+				Internal: err,
+			}
+		}
+	}
+
+	top, err := procsCache.get(etx.Request().Context())
+	if err != nil {
+		return &echo.HTTPError{
+			Code:     http.StatusInternalServerError,
+			Message:  "failed to get process information",
+			Internal: err,
+		}
+	}
+	return httpx.SendJSON(etx, &TopProcs{
+		ByCPU: top.ByCPU[:min(count, len(top.ByCPU))],
+		ByMem: top.ByMem[:min(count, len(top.ByMem))],
+	})
+}
+
+// GetTopProcs - gets the top processes by CPU and memory usage from an agent
+func GetTopProcs(gtx context.Context, client *httpx.Client) (*TopProcs, error) {
+	top := &TopProcs{}
+	if err := client.Get(gtx, "/api/v0/procs").LoadClose(top); err != nil {
+		return nil, errx.Errf(err, "failed to get processes from agent")
+	}
+	return top, nil
+}
@@ -0,0 +1,330 @@
+package mon
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+	"github.com/varunamachi/libx/errx"
+	"github.com/varunamachi/libx/httpx"
+)
+
+var (
+	ErrPushTimeout = errors.New("mon.push.timeout")
+	ErrPushDenied  = errors.New("mon.push.denied")
+)
+
+type AgentMode string
+
+const (
+	// AgentPull - the monitor polls the agent, this is the default
+	AgentPull AgentMode = "pull"
+
+	// AgentPush - the agent posts its samples to the monitor, for nodes that
+	// the monitor cannot reach
+	AgentPush AgentMode = "push"
+)
+
+const (
+	defaultPushInterval  = 5 * time.Second
+	defaultPushTimeout   = 30 * time.Second
+	defaultMaxBuffered   = 3600
+	maxSamplesPerRequest = 200
+	pushRequestTimeout   = 10 * time.Second
+)
+
+// pushUser - the monitor user the agent of the node pushes samples as
+func (ac *AgentConfig) pushUser() string {
+	if ac.PushUser != "" {
+		return ac.PushUser
+	}
+	return ac.Name
+}
+
+// PushSample - a sample taken by the agent at the given time
+type PushSample struct {
+	Time time.Time `json:"time"`
+	Data *SysInfo  `json:"data"`
+}
+
+// PushRequest - samples of a node in the order they were taken
+type PushRequest struct {
+	Node    string        `json:"node"`
+	Samples []*PushSample `json:"samples"`
+}
+
+// PushConfig - agent side settings for pushing samples to the monitor.
+// Samples are buffered while the monitor is unreachable, the oldest ones are
+// dropped once MaxBuffered is reached
+type PushConfig struct {
+	MonitorURL   string
+	Node         string
+	IntervalSecs int
+	MaxBuffered  int
+	AuthData     httpx.AuthData
+}
+
+// RunPusher - samples the node every interval and posts the samples to the
+// monitor until the context is done
+func RunPusher(gtx context.Context, cfg *PushConfig) error {
+	interval := time.Duration(cfg.IntervalSecs) * time.Second
+	if interval <= 0 {
+		interval = defaultPushInterval
+	}
+	maxBuffered := cfg.MaxBuffered
+	if maxBuffered <= 0 {
+		maxBuffered = defaultMaxBuffered
+	}
+
+	client := httpx.NewCustomClient(
+		cfg.MonitorURL, "", httpx.DefaultTransport(), pushRequestTimeout)
+	loggedIn := false
+	online := true
+	buffer := make([]*PushSample, 0, 16)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		info, err := takeSample(gtx)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to collect system info")
+		} else {
+			buffer = append(buffer, &PushSample{Time: time.Now(), Data: info})
+			if len(buffer) > maxBuffered {
+				buffer = slices.Delete(buffer, 0, len(buffer)-maxBuffered)
+			}
+		}
+
+		for len(buffer) != 0 {
+			if cfg.AuthData != nil && !loggedIn {
+				if err = Login(gtx, client, cfg.AuthData); err != nil {
+					break
+				}
+				loggedIn = true
+			}
+
+			batch := buffer[:min(len(buffer), maxSamplesPerRequest)]
+			err = client.Post(gtx, &PushRequest{
+				Node:    cfg.Node,
+				Samples: batch,
+			}, "/api/v1/ingest").Close()
+			if err != nil {
+				// The token may have expired, login again on the next try
+				loggedIn = false
+				break
+			}
+			buffer = slices.Delete(buffer, 0, len(batch))
+		}
+
+		if err != nil && online {
+			log.Warn().Err(err).Str("monitor", cfg.MonitorURL).
+				Msg("failed to push samples, buffering them")
+		} else if err == nil && !online {
+			log.Info().Str("monitor", cfg.MonitorURL).
+				Msg("pushing samples again")
+		}
+		online = err == nil
+
+		select {
+		case <-gtx.Done():
+			return gtx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// pushReceiver - accepts samples from the push mode agents and reports the
+// agents that have stopped pushing as unreachable
+type pushReceiver struct {
+	mutex    sync.Mutex
+	agents   []*AgentConfig
+	timeout  time.Duration
+	lastSeen []time.Time
+	history  *history
+	out      chan *AgentResponse
+}
+
+func newPushReceiver(
+	agents []*AgentConfig,
+	timeoutSecs int,
+	hist *history) *pushReceiver {
+	timeout := time.Duration(timeoutSecs) * time.Second
+	if timeout <= 0 {
+		timeout = defaultPushTimeout
+	}
+
+	now := time.Now()
+	lastSeen := make([]time.Time, len(agents))
+	for idx := range lastSeen {
+		lastSeen[idx] = now
+	}
+	return &pushReceiver{
+		agents:   agents,
+		timeout:  timeout,
+		lastSeen: lastSeen,
+		history:  hist,
+		out:      make(chan *AgentResponse, len(agents)),
+	}
+}
+
+// ingest - the latest sample is handed over to the monitor like a polled one.
+// Older samples were buffered by the agent while it was offline, they only
+// go into the history so that alerts and handlers do not act on stale data.
+// Samples of a node are only accepted from its push user
+func (pr *pushReceiver) ingest(
+	gtx context.Context, userId string, req *PushRequest) error {
+	index := slices.IndexFunc(pr.agents, func(ac *AgentConfig) bool {
+		return ac.Name == req.Node
+	})
+	if index < 0 {
+		return errx.Errf(ErrUnknownNode, "unknown node '%s'", req.Node)
+	}
+	if pr.agents[index].Mode != AgentPush {
+		return errx.Errf(ErrUnknownNode,
+			"node '%s' is not configured in push mode", req.Node)
+	}
+	if userId != pr.agents[index].pushUser() {
+		return errx.Errf(ErrPushDenied,
+			"user '%s' is not allowed to push samples for node '%s'",
+			userId, req.Node)
+	}
+	samples := slices.DeleteFunc(req.Samples, func(ps *PushSample) bool {
+		return ps == nil || ps.Data == nil
+	})
+	if len(samples) == 0 {
+		return nil
+	}
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].Time.Before(samples[j].Time)
+	})
+
+	backlog := make([]*AgentResponse, 0, len(samples)-1)
+	for _, ps := range samples[:len(samples)-1] {
+		backlog = append(backlog, &AgentResponse{
+			Index: index,
+			Data:  ps.Data,
+			Time:  ps.Time,
+		})
+	}
+	pr.history.backfill(index, backlog)
+
+	pr.mutex.Lock()
+	pr.lastSeen[index] = time.Now()
+	pr.mutex.Unlock()
+
+	latest := samples[len(samples)-1]
+	select {
+	case pr.out <- &AgentResponse{
+		Index: index,
+		Data:  latest.Data,
+		Time:  latest.Time,
+	}:
+	case <-gtx.Done():
+		return gtx.Err()
+	}
+	return nil
+}
+
+// watch - reports the push mode agents that have not pushed for a while as
+// unreachable, once every second like the polled ones
+func (pr *pushReceiver) watch(gtx context.Context) error {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-gtx.Done():
+			return gtx.Err()
+		case <-ticker.C:
+		}
+
+		pr.mutex.Lock()
+		now := time.Now()
+		stale := make([]int, 0, len(pr.agents))
+		for idx, ac := range pr.agents {
+			if ac.Mode == AgentPush && now.Sub(pr.lastSeen[idx]) > pr.timeout {
+				stale = append(stale, idx)
+			}
+		}
+		pr.mutex.Unlock()
+
+		for _, idx := range stale {
+			resp := &AgentResponse{
+				Index: idx,
+				Time:  now,
+				Err: errx.Errf(ErrPushTimeout,
+					"agent '%s' has not pushed samples for %s",
+					pr.agents[idx].Name, pr.timeout),
+			}
+			select {
+			case pr.out <- resp:
+			case <-gtx.Done():
+				return gtx.Err()
+			}
+		}
+	}
+}
+
+// getPushEndpoints - without users anyone could push samples for any node, so
+// the endpoint refuses all requests in that case
+func getPushEndpoints(users []*User, pr *pushReceiver) []*httpx.Endpoint {
+	eps := []*httpx.Endpoint{
+		{
+			Method:   echo.POST,
+			Path:     "/ingest",
+			Category: "push",
+			Desc:     "Receive samples from a push mode agent",
+			Version:  "v1",
+			Handler: func(etx echo.Context) error {
+				if len(users) == 0 {
+					return &echo.HTTPError{
+						Code: http.StatusForbidden,
+						Message: "push mode requires users to be " +
+							"configured on the monitor",
+					}
+				}
+
+				var req PushRequest
+				if err := etx.Bind(&req); err != nil {
+					return &echo.HTTPError{
+						Code:     http.StatusBadRequest,
+						Message:  "invalid push request",
+						Internal: err,
+					}
+				}
+				if len(req.Samples) > maxSamplesPerRequest {
+					return &echo.HTTPError{
+						Code:    http.StatusBadRequest,
+						Message: "too many samples in push request",
+					}
+				}
+				err := pr.ingest(
+					etx.Request().Context(), httpx.GetUserId(etx), &req)
+				if errors.Is(err, ErrUnknownNode) {
+					return &echo.HTTPError{
+						Code:     http.StatusNotFound,
+						Message:  "unknown push node",
+						Internal: err,
+					}
+				}
+				if errors.Is(err, ErrPushDenied) {
+					return &echo.HTTPError{
+						Code:     http.StatusForbidden,
+						Message:  "not allowed to push samples for the node",
+						Internal: err,
+					}
+				}
+				if err != nil {
+					return errx.Wrap(err)
+				}
+				return etx.NoContent(http.StatusNoContent)
+			},
+		},
+	}
+	return secureAll(users, eps...)
+}
@@ -2,12 +2,16 @@ package mon
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/labstack/echo/v4"
-	"github.com/stianeikeland/go-rpio/v4"
+	"github.com/rs/zerolog/log"
 	"github.com/varunamachi/libx/errx"
 	"github.com/varunamachi/libx/httpx"
 )
@@ -15,122 +19,438 @@ import (
 var (
 	ErrRelayIndexExceeded    = errors.New("mon.relay.indexExceeded")
 	ErrRelayCtlUninitialized = errors.New("mon.relay.uninitialized")
+	ErrInvalidSwitchOp       = errors.New("mon.relay.invalidOp")
 )
 
+// maxStagger - upper limit for the delay between switching relays in bulk,
+// guards against a request holding the relays for too long
+const maxStagger = 10 * time.Second
+
+type SwitchOp string
+
+const (
+	SwitchOn     SwitchOp = "on"
+	SwitchOff    SwitchOp = "off"
+	SwitchToggle SwitchOp = "toggle"
+)
+
+// ToSwitchOp - parses the operation to perform on a relay. Apart from on, off
+// and toggle, true and false are accepted for compatibility with older clients
+func ToSwitchOp(op string) (SwitchOp, error) {
+	switch strings.ToLower(op) {
+	case "on", "true":
+		return SwitchOn, nil
+	case "off", "false":
+		return SwitchOff, nil
+	case "toggle":
+		return SwitchToggle, nil
+	}
+	return "", errx.Errf(ErrInvalidSwitchOp,
+		"invalid switch operation '%s', should be one of: on | off | toggle",
+		op)
+}
+
+func (op SwitchOp) apply(cur bool) bool {
+	switch op {
+	case SwitchOn:
+		return true
+	case SwitchOff:
+		return false
+	}
+	return !cur
+}
+
+// BulkSwitchRequest - operations to perform keyed by slot. Relays are switched
+// in the order of their slots with StaggerMs between them, so that powering
+// on many nodes together does not cause an inrush spike
+type BulkSwitchRequest struct {
+	Ops       map[int]SwitchOp `json:"ops"`
+	StaggerMs int              `json:"staggerMs"`
+}
+
 type RelayController struct {
-	pins        []rpio.Pin
+	mutex       sync.Mutex
+	gpio        Gpio
+	relays      []*Relay
+	stateFile   string
 	inited      bool
-	isNO        bool
 	cachedState []bool
+	outputs     []bool
+	guessed     []bool
+	changes     []uint64
+	listeners   []func(*RelayChange)
+}
+
+// RelayChange - a relay that changed its state
+type RelayChange struct {
+	Slot  int       `json:"slot"`
+	Relay string    `json:"relay"`
+	Host  string    `json:"host,omitempty"`
+	On    bool      `json:"on"`
+	Time  time.Time `json:"time"`
+}
+
+// Relay - a relay connected to a GPIO pin. Host is the name of the node
+// powered by the relay, it can be empty if the relay does not power a node
+type Relay struct {
+	Name           string      `json:"name"`
+	Pin            uint8       `json:"pin"`
+	IsNormallyOpen bool        `json:"isNormallyOpen"`
+	Host           string      `json:"host"`
+	StartupMode    StartupMode `json:"startupMode"`
 }
 
+// RelayConfig - relays and the GPIO backend driving them. State of the relays
+// is persisted to StateFile whenever it changes, so that it can be restored
+// when the controller is restarted
 type RelayConfig struct {
-	GpioPins       []uint8 `json:"gpioPins"`
-	IsNormallyOpen bool    `json:"isNormallyOpen"`
+	Driver    GpioDriverType `json:"driver"`
+	Chip      string         `json:"chip"`
+	StateFile string         `json:"stateFile"`
+	Relays    []*Relay       `json:"relays"`
 }
 
-// func NewDefaultRelayController() *RelayController {
-// 	return NewRelayController(
-// 		[]uint8{22, 23, 24, 25},
-// 		true,
-// 	)
-// }
+// DefaultRelayConfig - four normally open relays on GPIO 22 to 25, used when
+// the picl config does not describe the relays
+func DefaultRelayConfig() *RelayConfig {
+	pins := []uint8{22, 23, 24, 25}
+	cfg := &RelayConfig{
+		Relays: make([]*Relay, len(pins)),
+	}
+	for idx, pin := range pins {
+		cfg.Relays[idx] = &Relay{
+			Name:           fmt.Sprintf("relay-%d", idx),
+			Pin:            pin,
+			IsNormallyOpen: true,
+		}
+	}
+	return cfg
+}
 
 func NewRelayController(cfg *RelayConfig) (*RelayController, error) {
-	if err := rpio.Open(); err != nil {
+	gpio, err := NewGpio(cfg.Driver, cfg.Chip)
+	if err != nil {
 		return nil, err
 	}
+	return newRelayController(cfg, gpio)
+}
 
+// newRelayController - puts the relays to their startup state. Relays whose
+// state is not known are left alone, their pins become outputs only when
+// they are first switched
+func newRelayController(
+	cfg *RelayConfig, gpio Gpio) (*RelayController, error) {
 	rc := &RelayController{
-		pins:        make([]rpio.Pin, len(cfg.GpioPins)),
-		cachedState: make([]bool, len(cfg.GpioPins)),
-		isNO:        cfg.IsNormallyOpen,
+		gpio:        gpio,
+		relays:      cfg.Relays,
+		stateFile:   cfg.StateFile,
+		cachedState: make([]bool, len(cfg.Relays)),
+		outputs:     make([]bool, len(cfg.Relays)),
+		guessed:     make([]bool, len(cfg.Relays)),
+		changes:     make([]uint64, len(cfg.Relays)),
 		inited:      false,
 	}
-	for idx, pin := range cfg.GpioPins {
-		pin := rpio.Pin(pin)
-		pin.Output()
-		pin.Write(rc.toState(false)) //Initially relay is closed!
-		rc.pins[idx] = pin
+
+	persisted, err := loadRelayStates(cfg.StateFile)
+	if err != nil {
+		log.Error().Err(err).Msg("ignoring persisted relay states")
+		persisted = relayStates{}
+	}
+
+	for idx, relay := range cfg.Relays {
+		on, drive := rc.startupState(idx, persisted)
+		rc.cachedState[idx] = on
+		if !drive {
+			continue
+		}
+		if err := gpio.Output(relay.Pin, rc.toLevel(idx, on)); err != nil {
+			gpio.Close()
+			return nil, errx.Wrap(err)
+		}
+		rc.outputs[idx] = true
 	}
+	rc.persist()
 	rc.inited = true
 	return rc, nil
 }
 
+// startupState - decides the initial state of the relay at given slot and
+// whether the relay should be driven to it. The startup mode applies only to
+// relays with a persisted state. Others, such as on the first start, after
+// the state file is lost or a relay is renamed, keep their current level so
+// that a restart does not cut power to running nodes. If even the level is
+// not known, the relay is taken to be on
+func (rc *RelayController) startupState(
+	slot int, persisted relayStates) (on, drive bool) {
+	relay := rc.relays[slot]
+	wasOn, known := persisted[relay.Name]
+	if !known {
+		high, err := rc.gpio.Read(relay.Pin)
+		if err != nil {
+			log.Warn().Err(err).
+				Str("relay", relay.Name).
+				Msg("state of relay is not known, leaving it as it is")
+			rc.guessed[slot] = true
+			return true, false
+		}
+		on = rc.fromLevel(slot, high)
+		log.Info().
+			Str("relay", relay.Name).
+			Bool("on", on).
+			Msg("no persisted state for relay, keeping its current level")
+		return on, false
+	}
+
+	switch relay.StartupMode {
+	case StartupOn:
+		return true, true
+	case StartupOff:
+		if wasOn {
+			log.Warn().
+				Str("relay", relay.Name).
+				Msg("relay was on before restart, turning it off")
+		}
+		return false, true
+	}
+	return wasOn, true
+}
+
+// persist - writes the cached relay states to the state file. Failures are
+// only logged since the relays are already switched by then. Guessed states
+// are left out so that they are not taken as known on the next start
+func (rc *RelayController) persist() {
+	states := make(relayStates, len(rc.relays))
+	for slot, relay := range rc.relays {
+		if rc.guessed[slot] {
+			continue
+		}
+		states[relay.Name] = rc.cachedState[slot]
+	}
+	if err := states.store(rc.stateFile); err != nil {
+		log.Error().Err(err).Msg("failed to persist relay states")
+	}
+}
+
+// OnChange - registers a function that is called whenever a relay changes its
+// state. It is called with the lock held, so it should neither block nor use
+// the controller
+func (rc *RelayController) OnChange(fn func(*RelayChange)) {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	rc.listeners = append(rc.listeners, fn)
+}
+
+// setCached - updates the cached state and informs the listeners if it has
+// changed, lock should be held by the caller
+func (rc *RelayController) setCached(slot int, state bool) {
+	if rc.cachedState[slot] == state {
+		return
+	}
+	rc.cachedState[slot] = state
+	if len(rc.listeners) == 0 {
+		return
+	}
+	change := &RelayChange{
+		Slot:  slot,
+		Relay: rc.relays[slot].Name,
+		Host:  rc.relays[slot].Host,
+		On:    state,
+		Time:  time.Now(),
+	}
+	for _, fn := range rc.listeners {
+		fn(change)
+	}
+}
+
+// Relays - gives the configuration of relays in the order of their slots
+func (rc *RelayController) Relays() []*Relay {
+	return rc.relays
+}
+
 func (rc *RelayController) SetState(slot int, state bool) error {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	if err := rc.check(slot); err != nil {
+		return err
+	}
+	if err := rc.write(slot, state); err != nil {
+		return err
+	}
+	rc.persist()
+	return nil
+}
+
+// Switch - performs the operation on the relay at given slot and gives its
+// new state
+func (rc *RelayController) Switch(slot int, op SwitchOp) (bool, error) {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	if err := rc.check(slot); err != nil {
+		return false, err
+	}
+	state := op.apply(rc.cachedState[slot])
+	if err := rc.write(slot, state); err != nil {
+		return false, err
+	}
+	rc.persist()
+	return state, nil
+}
+
+// SwitchAll - performs the operation on all the relays. If any of the relays
+// fails to switch, the ones already switched are restored to their previous
+// state
+func (rc *RelayController) SwitchAll(op SwitchOp) error {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
 	if !rc.inited {
 		return errx.Errf(ErrRelayCtlUninitialized,
 			"relay controller has not been initialized")
 	}
 
-	if slot < 0 || slot >= len(rc.pins) {
+	prev := slices.Clone(rc.cachedState)
+	for slot := range rc.relays {
+		if err := rc.write(slot, op.apply(prev[slot])); err != nil {
+			for restore := 0; restore < slot; restore++ {
+				if rerr := rc.write(restore, prev[restore]); rerr != nil {
+					log.Error().Err(rerr).
+						Str("relay", rc.relays[restore].Name).
+						Msg("failed to restore relay state")
+				}
+			}
+			rc.persist()
+			return err
+		}
+	}
+	rc.persist()
+	return nil
+}
+
+// SwitchMany - validates all the operations and then performs them in the
+// order of slots, waiting for the stagger between each of them. The lock is
+// not held while waiting, so that state queries are not blocked
+func (rc *RelayController) SwitchMany(
+	ops map[int]SwitchOp, stagger time.Duration) error {
+	slots := make([]int, 0, len(ops))
+	for slot, op := range ops {
+		if err := rc.check(slot); err != nil {
+			return err
+		}
+		if _, err := ToSwitchOp(string(op)); err != nil {
+			return err
+		}
+		slots = append(slots, slot)
+	}
+	slices.Sort(slots)
+
+	for idx, slot := range slots {
+		if idx != 0 && stagger > 0 {
+			time.Sleep(stagger)
+		}
+		op, _ := ToSwitchOp(string(ops[slot]))
+		if _, err := rc.Switch(slot, op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rc *RelayController) check(slot int) error {
+	if !rc.inited {
+		return errx.Errf(ErrRelayCtlUninitialized,
+			"relay controller has not been initialized")
+	}
+
+	if slot < 0 || slot >= len(rc.relays) {
 		return errx.Errf(ErrRelayIndexExceeded,
-			"index is less than 0 or than number of relays (%d) ", len(rc.pins))
+			"index is less than 0 or than number of relays (%d) ",
+			len(rc.relays))
 	}
-	rc.pins[slot].Output()
-	pinState := rc.toState(state)
-	rc.pins[slot].Write(pinState)
-	rc.cachedState[slot] = state
+	return nil
+}
+
+// write - switches the relay and updates the cache, lock should be held by
+// the caller. Pins left alone at startup are made outputs on the first write
+func (rc *RelayController) write(slot int, state bool) error {
+	pin := rc.relays[slot].Pin
+	level := rc.toLevel(slot, state)
+	if !rc.outputs[slot] {
+		if err := rc.gpio.Output(pin, level); err != nil {
+			return errx.Wrap(err)
+		}
+		rc.outputs[slot] = true
+		rc.guessed[slot] = false
+	} else if err := rc.gpio.Write(pin, level); err != nil {
+		return errx.Wrap(err)
+	}
+	rc.changes[slot]++
+	rc.setCached(slot, state)
 	return nil
 }
 
 func (rc *RelayController) RefreshStates() ([]bool, error) {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
 	if !rc.inited {
 		return nil, errx.Errf(ErrRelayCtlUninitialized,
 			"relay controller has not been initialized")
 	}
 
-	for index, pin := range rc.pins {
-		rc.cachedState[index] = rc.fromState(pin.Read())
+	for index, relay := range rc.relays {
+		high, err := rc.gpio.Read(relay.Pin)
+		if errors.Is(err, ErrGpioLevelUnknown) {
+			continue
+		}
+		if err != nil {
+			return nil, errx.Wrap(err)
+		}
+		rc.setCached(index, rc.fromLevel(index, high))
 	}
-	return rc.cachedState, nil
+	return slices.Clone(rc.cachedState), nil
 }
 
 func (rc *RelayController) GetState(slot int) (bool, error) {
-	if !rc.inited {
-		return false, errx.Errf(ErrRelayCtlUninitialized,
-			"relay controller has not been initialized")
-	}
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
 
-	if slot < 0 || slot >= len(rc.pins) {
-		return false, errx.Errf(ErrRelayIndexExceeded,
-			"index is less than 0 or than number of relays (%d) ", len(rc.pins))
+	if err := rc.check(slot); err != nil {
+		return false, err
 	}
 	return rc.cachedState[slot], nil
 }
 
 func (rc *RelayController) GetStates() ([]bool, error) {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
 	if !rc.inited {
 		return nil, errx.Errf(ErrRelayCtlUninitialized,
 			"relay controller has not been initialized")
 	}
-	return rc.cachedState, nil
+	return slices.Clone(rc.cachedState), nil
 }
 
 func (rc *RelayController) Close() error {
-	return rpio.Close()
+	return rc.gpio.Close()
 }
 
-func (rc *RelayController) toState(on bool) rpio.State {
-	if rc.isNO {
-		if on {
-			return rpio.Low
-		}
-		return rpio.High
-	} else {
-		if on {
-			return rpio.High
-		}
-		return rpio.Low
+// toLevel - gives the pin level (true for high) that puts the relay at given
+// slot to the given state
+func (rc *RelayController) toLevel(slot int, on bool) bool {
+	if rc.relays[slot].IsNormallyOpen {
+		return !on
 	}
+	return on
 }
 
-func (rc *RelayController) fromState(state rpio.State) bool {
-	if rc.isNO {
-		return state == rpio.Low
-	} else {
-		return state != rpio.High
+func (rc *RelayController) fromLevel(slot int, high bool) bool {
+	if rc.relays[slot].IsNormallyOpen {
+		return !high
 	}
+	return high
 }
 
 func getRelayEndpoints(rc *RelayController) []*httpx.Endpoint {
@@ -160,9 +480,16 @@ func getRelayEndpoints(rc *RelayController) []*httpx.Endpoint {
 						Internal: err,
 					}
 				}
-				state := strings.EqualFold(stateStr, "true")
+				op, err := ToSwitchOp(stateStr)
+				if err != nil {
+					return &echo.HTTPError{
+						Message:  "invalid state, should be on, off or toggle",
+						Code:     http.StatusBadRequest,
+						Internal: err,
+					}
+				}
 
-				if err = rc.SetState(slot, state); err != nil {
+				if _, err = rc.Switch(slot, op); err != nil {
 					return &echo.HTTPError{
 						Message:  "failed to set state",
 						Code:     http.StatusInternalServerError,
@@ -195,16 +522,76 @@ func getRelayEndpoints(rc *RelayController) []*httpx.Endpoint {
 					}
 				}
 
-				stateStr := etx.Param("state")
-				state := strings.EqualFold(stateStr, "true")
-
-				for slot := range rc.pins {
-					if err := rc.SetState(slot, state); err != nil {
-						return &echo.HTTPError{
-							Message:  "failed to set state",
-							Code:     http.StatusInternalServerError,
-							Internal: err,
-						}
+				op, err := ToSwitchOp(etx.Param("state"))
+				if err != nil {
+					return &echo.HTTPError{
+						Message:  "invalid state, should be on, off or toggle",
+						Code:     http.StatusBadRequest,
+						Internal: err,
+					}
+				}
+
+				if err := rc.SwitchAll(op); err != nil {
+					return &echo.HTTPError{
+						Message:  "failed to set state",
+						Code:     http.StatusInternalServerError,
+						Internal: err,
+					}
+				}
+
+				vals, err := rc.GetStates()
+				if err != nil {
+					return &echo.HTTPError{
+						Message:  "failed to get pin states",
+						Code:     http.StatusInternalServerError,
+						Internal: err,
+					}
+				}
+				return httpx.SendJSON(etx, vals)
+			},
+		},
+		{
+			Method:   echo.POST,
+			Path:     "/switch/bulk",
+			Category: "switch",
+			Desc:     "Switch many relays with an optional delay between them",
+			Version:  "v1",
+			Handler: func(etx echo.Context) error {
+				if rc == nil {
+					return &echo.HTTPError{
+						Message: "gpio features not enabled",
+						Code:    http.StatusInternalServerError,
+					}
+				}
+
+				var req BulkSwitchRequest
+				if err := etx.Bind(&req); err != nil {
+					return &echo.HTTPError{
+						Message:  "invalid bulk switch request",
+						Code:     http.StatusBadRequest,
+						Internal: err,
+					}
+				}
+				stagger := time.Duration(req.StaggerMs) * time.Millisecond
+				if stagger < 0 || stagger > maxStagger {
+					return &echo.HTTPError{
+						Message: fmt.Sprintf(
+							"stagger should be between 0 and %dms",
+							maxStagger.Milliseconds()),
+						Code: http.StatusBadRequest,
+					}
+				}
+
+				if err := rc.SwitchMany(req.Ops, stagger); err != nil {
+					code := http.StatusInternalServerError
+					if errors.Is(err, ErrRelayIndexExceeded) ||
+						errors.Is(err, ErrInvalidSwitchOp) {
+						code = http.StatusBadRequest
+					}
+					return &echo.HTTPError{
+						Message:  "failed to switch relays",
+						Code:     code,
+						Internal: err,
 					}
 				}
 
@@ -294,7 +681,7 @@ func getRelayEndpoints(rc *RelayController) []*httpx.Endpoint {
 					}
 				}
 
-				return etx.String(http.StatusOK, strconv.Itoa(len(rc.pins)))
+				return etx.String(http.StatusOK, strconv.Itoa(len(rc.relays)))
 			},
 		},
 	}
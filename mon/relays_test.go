@@ -0,0 +1,192 @@
+package mon
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// recordingGpio - sim GPIO that remembers which pins were driven
+type recordingGpio struct {
+	Gpio
+	driven map[uint8]int
+}
+
+func newRecordingGpio() *recordingGpio {
+	return &recordingGpio{
+		Gpio:   newSimGpio(),
+		driven: make(map[uint8]int),
+	}
+}
+
+func (rg *recordingGpio) Output(pin uint8, high bool) error {
+	rg.driven[pin]++
+	return rg.Gpio.Output(pin, high)
+}
+
+func (rg *recordingGpio) Write(pin uint8, high bool) error {
+	rg.driven[pin]++
+	return rg.Gpio.Write(pin, high)
+}
+
+func testRelayConfig(stateFile string, modes ...StartupMode) *RelayConfig {
+	cfg := &RelayConfig{Driver: GpioSim, StateFile: stateFile}
+	for idx, mode := range modes {
+		cfg.Relays = append(cfg.Relays, &Relay{
+			Name:           "relay-" + string(rune('a'+idx)),
+			Pin:            uint8(20 + idx),
+			IsNormallyOpen: true,
+			StartupMode:    mode,
+		})
+	}
+	return cfg
+}
+
+func writeStateFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "relays.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRelayStartupUnknownState(t *testing.T) {
+	modes := []StartupMode{StartupLast, StartupOn, StartupOff}
+	tests := []struct {
+		name      string
+		stateFile func(t *testing.T) string
+	}{
+		{"missing file", func(t *testing.T) string {
+			return filepath.Join(t.TempDir(), "missing", "relays.json")
+		}},
+		{"corrupt file", func(t *testing.T) string {
+			return writeStateFile(t, `{"relay-a": tru`)
+		}},
+		{"renamed relays", func(t *testing.T) string {
+			return writeStateFile(t,
+				`{"old-a": false, "old-b": false, "old-c": true}`)
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := test.stateFile(t)
+			gpio := newRecordingGpio()
+			rc, err := newRelayController(testRelayConfig(path, modes...), gpio)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Whatever the startup mode, none of the relays is driven
+			for pin, count := range gpio.driven {
+				t.Errorf("pin %d was driven %d times", pin, count)
+			}
+
+			// Nothing is known about them, so nothing is persisted either
+			states, err := loadRelayStates(path)
+			if err == nil && len(states) != 0 {
+				t.Errorf("guessed states were persisted: %v", states)
+			}
+
+			// Switching a relay drives it and makes its state known
+			if err := rc.SetState(2, false); err != nil {
+				t.Fatal(err)
+			}
+			if gpio.driven[22] != 1 {
+				t.Errorf("expected pin 22 to be driven once, got %d",
+					gpio.driven[22])
+			}
+			states, err = loadRelayStates(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if on, found := states["relay-c"]; !found || on {
+				t.Errorf("switched relay was not persisted: %v", states)
+			}
+			if len(states) != 1 {
+				t.Errorf("expected only the switched relay, got: %v", states)
+			}
+		})
+	}
+}
+
+func TestRelayStartupKeepsLevel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "relays.json")
+	gpio := newRecordingGpio()
+
+	// Normally open relay with its pin low is on
+	if err := gpio.Gpio.Write(20, false); err != nil {
+		t.Fatal(err)
+	}
+	rc, err := newRelayController(testRelayConfig(path, StartupOff), gpio)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gpio.driven[20] != 0 {
+		t.Error("relay with a known level was driven")
+	}
+	if on, _ := rc.GetState(0); !on {
+		t.Error("relay did not keep its level")
+	}
+	states, err := loadRelayStates(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if on, found := states["relay-a"]; !found || !on {
+		t.Errorf("level read from the pin was not persisted: %v", states)
+	}
+}
+
+func TestRelayStartupKnownState(t *testing.T) {
+	path := writeStateFile(t,
+		`{"relay-a": true, "relay-b": false, "relay-c": true}`)
+	gpio := newRecordingGpio()
+	rc, err := newRelayController(
+		testRelayConfig(path, StartupLast, StartupOn, StartupOff), gpio)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []bool{true, true, false}
+	states, err := rc.GetStates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for idx, on := range states {
+		if on != want[idx] {
+			t.Errorf("relay %d: expected %v, got %v", idx, want[idx], on)
+		}
+		if gpio.driven[uint8(20+idx)] != 1 {
+			t.Errorf("relay %d with known state was not driven", idx)
+		}
+	}
+}
+
+func TestRelayCycleSkippedAfterChange(t *testing.T) {
+	path := writeStateFile(t, `{"relay-a": true}`)
+	rc, err := newRelayController(
+		testRelayConfig(path, StartupLast), newRecordingGpio())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing else happens, the relay is turned back on
+	if err := rc.Cycle(0, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if on, _ := rc.GetState(0); !on {
+		t.Error("relay was not turned back on")
+	}
+
+	// Turned off by someone while cycling, it stays off
+	if err := rc.startCycle(0, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.SetState(0, false); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if on, _ := rc.GetState(0); on {
+		t.Error("relay switched during the cycle was turned back on")
+	}
+}
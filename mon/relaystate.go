@@ -0,0 +1,67 @@
+package mon
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/varunamachi/libx/errx"
+	"github.com/varunamachi/libx/iox"
+)
+
+// StartupMode - decides the state a relay is put into when the relay
+// controller starts. It applies only when the state of the relay before the
+// restart is known, other relays are left as they are
+type StartupMode string
+
+const (
+	// StartupLast - restore the state persisted before the last shutdown
+	StartupLast StartupMode = "last"
+
+	// StartupOn - turn the relay on
+	StartupOn StartupMode = "on"
+
+	// StartupOff - turn the relay off, even if it was on before the restart
+	StartupOff StartupMode = "off"
+)
+
+// relayStates - persisted relay states, keyed by relay name
+type relayStates map[string]bool
+
+func loadRelayStates(path string) (relayStates, error) {
+	states := relayStates{}
+	if path == "" || !iox.ExistsAsFile(path) {
+		return states, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errx.Errf(err, "failed to read relay state file '%s'", path)
+	}
+	if err := json.Unmarshal(data, &states); err != nil {
+		return nil, errx.Errf(err, "failed to parse relay state file '%s'", path)
+	}
+	return states, nil
+}
+
+// store - writes the states to a temporary file and renames it, so that a
+// crash while writing does not leave a truncated state file
+func (rs relayStates) store(path string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(rs, "", "    ")
+	if err != nil {
+		return errx.Wrap(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errx.Errf(err, "failed to create directory for relay state")
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return errx.Errf(err, "failed to write relay state file '%s'", tmp)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return errx.Errf(err, "failed to replace relay state file '%s'", path)
+	}
+	return nil
+}
@@ -15,6 +15,15 @@ if [ -f "${logFilePrefix}.log" ] ; then
 fi
 touch "${logFilePrefix}.log"
 
+# Agent settings, e.g. PICL_AGENT_NAME, PICL_AGENT_USER_ID and
+# PICL_AGENT_PASSWORD. PICL_PUSH_MONITOR_URL along with PICL_PUSH_USER_ID and
+# PICL_PUSH_PASSWORD enable pushing samples to the monitor
+if [ -f "${deploymentDir}/agent.env" ] ; then
+    set -a
+    . "${deploymentDir}/agent.env"
+    set +a
+fi
+
 # May be use PID file later
 killall "${serverExe}" 
 
@@ -0,0 +1,138 @@
+package mon
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// SamplingConfig - intervals at which the agent refreshes the metrics that
+// are expensive to collect. Requests in between get the cached values, so
+// that frequent polls stay cheap. A zero interval collects the metric on every
+// request. Scrapes of the metrics endpoint get the last sample taken for
+// the monitor as long as it is not older than MetricsSecs
+type SamplingConfig struct {
+	DiskSecs       int `json:"diskSecs"`
+	SensorSecs     int `json:"sensorSecs"`
+	ThrottlingSecs int `json:"throttlingSecs"`
+	ProcsSecs      int `json:"procsSecs"`
+	MetricsSecs    int `json:"metricsSecs"`
+}
+
+func DefaultSamplingConfig() *SamplingConfig {
+	return &SamplingConfig{
+		DiskSecs:       60,
+		SensorSecs:     5,
+		ThrottlingSecs: 10,
+		ProcsSecs:      5,
+		MetricsSecs:    15,
+	}
+}
+
+// cachedMetric - last collected value of a metric. Concurrent requests for a
+// stale value wait for a single collection rather than each running one.
+// Failed collections are not cached, the next request tries again
+type cachedMetric[T any] struct {
+	mutex     sync.Mutex
+	interval  time.Duration
+	collect   func(gtx context.Context) (T, error)
+	value     T
+	collected time.Time
+}
+
+func newCachedMetric[T any](
+	interval time.Duration,
+	collect func(gtx context.Context) (T, error)) *cachedMetric[T] {
+	return &cachedMetric[T]{interval: interval, collect: collect}
+}
+
+func (cm *cachedMetric[T]) get(gtx context.Context) (T, error) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	if !cm.collected.IsZero() && time.Since(cm.collected) < cm.interval {
+		return cm.value, nil
+	}
+	value, err := cm.collect(gtx)
+	if err != nil {
+		return value, err
+	}
+	cm.value, cm.collected = value, time.Now()
+	return value, nil
+}
+
+// set - caches a value collected elsewhere
+func (cm *cachedMetric[T]) set(value T) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	cm.value, cm.collected = value, time.Now()
+}
+
+func (cm *cachedMetric[T]) setInterval(interval time.Duration) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	cm.interval = interval
+}
+
+type sensorReadings struct {
+	sensors []*Sensor
+	fans    []*Fan
+}
+
+func secs(count int) time.Duration {
+	return time.Duration(count) * time.Second
+}
+
+var (
+	defaultSampling = DefaultSamplingConfig()
+
+	diskCache = newCachedMetric(
+		secs(defaultSampling.DiskSecs), diskUsages)
+
+	sensorCache = newCachedMetric(
+		secs(defaultSampling.SensorSecs),
+		func(context.Context) (*sensorReadings, error) {
+			sensors, fans := readSensors()
+			return &sensorReadings{sensors: sensors, fans: fans}, nil
+		})
+
+	throttlingCache = newCachedMetric(
+		secs(defaultSampling.ThrottlingSecs), throttling)
+
+	// Processes are always collected with the maximum count, requests for
+	// fewer get a prefix of the lists
+	procsCache = newCachedMetric(
+		secs(defaultSampling.ProcsSecs),
+		func(gtx context.Context) (*TopProcs, error) {
+			return topProcesses(gtx, maxTopProcs)
+		})
+
+	// CPU usage and network rates are computed since the previous sample, so
+	// the metrics endpoint serves the samples taken for the monitor instead
+	// of taking its own on every scrape and skewing them
+	sampleCache = newCachedMetric(
+		secs(defaultSampling.MetricsSecs), systemInfo)
+)
+
+// takeSample - collects the system info for the monitor and keeps it for the
+// metrics endpoint
+func takeSample(gtx context.Context) (*SysInfo, error) {
+	info, err := systemInfo(gtx)
+	if err == nil {
+		sampleCache.set(info)
+	}
+	return info, err
+}
+
+// ConfigureSampling - sets the intervals at which the agent collects the
+// expensive metrics, missing config means the defaults
+func ConfigureSampling(cfg *SamplingConfig) {
+	if cfg == nil {
+		cfg = DefaultSamplingConfig()
+	}
+	diskCache.setInterval(secs(cfg.DiskSecs))
+	sensorCache.setInterval(secs(cfg.SensorSecs))
+	throttlingCache.setInterval(secs(cfg.ThrottlingSecs))
+	procsCache.setInterval(secs(cfg.ProcsSecs))
+	sampleCache.setInterval(secs(cfg.MetricsSecs))
+}
@@ -0,0 +1,307 @@
+package mon
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/varunamachi/libx/errx"
+	"github.com/varunamachi/libx/httpx"
+	"github.com/varunamachi/picl/xcutr"
+)
+
+var (
+	ErrInvalidSchedule  = errors.New("mon.schedule.invalid")
+	ErrScheduleNotFound = errors.New("mon.schedule.notFound")
+)
+
+const defaultShutdownTimeout = 2 * time.Minute
+
+// Schedule - powers a group of nodes on or off whenever the cron expression
+// matches. Nodes are identified by host or relay name, all the relays are
+// switched if no nodes are given. With Graceful set, nodes are shut down over
+// SSH before their relays are turned off
+type Schedule struct {
+	Name                string      `json:"name"`
+	Cron                string      `json:"cron"`
+	Action              PowerAction `json:"action"`
+	Nodes               []string    `json:"nodes"`
+	Graceful            bool        `json:"graceful"`
+	ShutdownTimeoutSecs int         `json:"shutdownTimeoutSecs,omitempty"`
+}
+
+// ScheduleStatus - a schedule along with its next run. Schedules added through
+// the API are temporary, they are not saved to the config and are gone once
+// the monitor restarts
+type ScheduleStatus struct {
+	*Schedule
+	Next      *time.Time `json:"next,omitempty"`
+	Temporary bool       `json:"temporary,omitempty"`
+}
+
+type scheduleEntry struct {
+	sched     *Schedule
+	expr      *cronExpr
+	temporary bool
+}
+
+type scheduler struct {
+	mutex    sync.Mutex
+	entries  []*scheduleEntry
+	relayCtl *RelayController
+	agents   []*AgentConfig
+	events   *EventLog
+}
+
+func newScheduler(
+	schedules []*Schedule,
+	agents []*AgentConfig,
+	relayCtl *RelayController,
+	events *EventLog) (*scheduler, error) {
+	sc := &scheduler{
+		entries:  make([]*scheduleEntry, 0, len(schedules)),
+		relayCtl: relayCtl,
+		agents:   agents,
+		events:   events,
+	}
+	for _, sched := range schedules {
+		if err := sc.put(sched, false); err != nil {
+			return nil, err
+		}
+	}
+	return sc, nil
+}
+
+func (sc *scheduler) validate(sched *Schedule) (*cronExpr, error) {
+	if sched.Name == "" {
+		return nil, errx.Errf(ErrInvalidSchedule, "schedule name is empty")
+	}
+	if sched.Action != PowerOn && sched.Action != PowerOff {
+		return nil, errx.Errf(ErrInvalidSchedule,
+			"action of schedule '%s' should be on or off", sched.Name)
+	}
+	expr, err := parseCron(sched.Cron)
+	if err != nil {
+		return nil, err
+	}
+	if sc.relayCtl != nil {
+		for _, node := range sched.Nodes {
+			if _, err := sc.relayCtl.SlotOf(node); err != nil {
+				return nil, errx.Errf(err,
+					"invalid node in schedule '%s'", sched.Name)
+			}
+		}
+	}
+	return expr, nil
+}
+
+// put - adds the schedule, replacing the existing one with the same name.
+// Temporary schedules are the ones that do not come from the config
+func (sc *scheduler) put(sched *Schedule, temporary bool) error {
+	expr, err := sc.validate(sched)
+	if err != nil {
+		return err
+	}
+
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	entry := &scheduleEntry{sched: sched, expr: expr, temporary: temporary}
+	for idx, existing := range sc.entries {
+		if existing.sched.Name == sched.Name {
+			sc.entries[idx] = entry
+			return nil
+		}
+	}
+	sc.entries = append(sc.entries, entry)
+	return nil
+}
+
+func (sc *scheduler) remove(name string) error {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	for idx, entry := range sc.entries {
+		if entry.sched.Name == name {
+			sc.entries = slices.Delete(sc.entries, idx, idx+1)
+			return nil
+		}
+	}
+	return errx.Errf(ErrScheduleNotFound, "schedule '%s' not found", name)
+}
+
+func (sc *scheduler) list() []*ScheduleStatus {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	now := time.Now()
+	out := make([]*ScheduleStatus, len(sc.entries))
+	for idx, entry := range sc.entries {
+		out[idx] = &ScheduleStatus{
+			Schedule:  entry.sched,
+			Temporary: entry.temporary,
+		}
+		if next := entry.expr.next(now); !next.IsZero() {
+			out[idx].Next = &next
+		}
+	}
+	return out
+}
+
+// run - checks the schedules at the start of every minute and runs the ones
+// that match
+func (sc *scheduler) run(gtx context.Context) error {
+	for {
+		now := time.Now()
+		wait := now.Truncate(time.Minute).Add(time.Minute).Sub(now)
+		select {
+		case <-gtx.Done():
+			return gtx.Err()
+		case tick := <-time.After(wait):
+			tick = tick.Round(time.Minute)
+			sc.mutex.Lock()
+			for _, entry := range sc.entries {
+				if entry.expr.matches(tick) {
+					go sc.execute(entry.sched)
+				}
+			}
+			sc.mutex.Unlock()
+		}
+	}
+}
+
+func (sc *scheduler) execute(sched *Schedule) {
+	if sc.relayCtl == nil {
+		sc.events.Add(EventSchedule, "",
+			"skipping schedule '%s', relays are not available", sched.Name)
+		return
+	}
+
+	nodes := sched.Nodes
+	if len(nodes) == 0 {
+		for _, relay := range sc.relayCtl.Relays() {
+			nodes = append(nodes, relay.Name)
+		}
+	}
+
+	timeout := defaultShutdownTimeout
+	if sched.ShutdownTimeoutSecs > 0 {
+		timeout = time.Duration(sched.ShutdownTimeoutSecs) * time.Second
+	}
+
+	// Nodes are shut down in parallel, a slow node should not delay the others
+	wg := sync.WaitGroup{}
+	for _, node := range nodes {
+		wg.Add(1)
+		go func(node string) {
+			defer wg.Done()
+			sc.apply(sched, node, timeout)
+		}(node)
+	}
+	wg.Wait()
+}
+
+func (sc *scheduler) apply(
+	sched *Schedule, node string, timeout time.Duration) {
+	slot, err := sc.relayCtl.SlotOf(node)
+	if err != nil {
+		sc.events.Add(EventSchedule, node,
+			"schedule '%s': %v", sched.Name, err)
+		return
+	}
+
+	if sched.Action == PowerOff && sched.Graceful {
+		host := sc.relayCtl.Relays()[slot].Host
+		if opts := sc.sshOptsOf(host); opts != nil {
+			if err := ShutdownNode(opts, timeout); err != nil {
+				// Power is still cut, a node that did not shut down in time
+				// is most likely hung
+				sc.events.Add(EventSchedule, node,
+					"schedule '%s': graceful shutdown failed: %v",
+					sched.Name, err)
+			}
+		}
+	}
+
+	on := sched.Action == PowerOn
+	if err := sc.relayCtl.SetState(slot, on); err != nil {
+		sc.events.Add(EventSchedule, node,
+			"schedule '%s': failed to power %s: %v",
+			sched.Name, sched.Action, err)
+		return
+	}
+	sc.events.Add(EventSchedule, node,
+		"schedule '%s': powered %s", sched.Name, sched.Action)
+}
+
+func (sc *scheduler) sshOptsOf(host string) *xcutr.SshConnOpts {
+	for _, agent := range sc.agents {
+		if host != "" && agent.Name == host {
+			return agent.SshOpts
+		}
+	}
+	return nil
+}
+
+func getScheduleEndpoints(sc *scheduler) []*httpx.Endpoint {
+	return []*httpx.Endpoint{
+		{
+			Method:   echo.GET,
+			Path:     "/schedule",
+			Category: "schedule",
+			Desc:     "Get all the power schedules along with their next run",
+			Version:  "v1",
+			Handler: func(etx echo.Context) error {
+				return httpx.SendJSON(etx, sc.list())
+			},
+		},
+		{
+			Method:   echo.POST,
+			Path:     "/schedule",
+			Category: "schedule",
+			Desc: "Add a power schedule or replace one with the same " +
+				"name, only until the monitor restarts",
+			Version: "v1",
+			Handler: func(etx echo.Context) error {
+				var sched Schedule
+				if err := etx.Bind(&sched); err != nil {
+					return &echo.HTTPError{
+						Message:  "invalid schedule",
+						Code:     http.StatusBadRequest,
+						Internal: err,
+					}
+				}
+				if err := sc.put(&sched, true); err != nil {
+					return &echo.HTTPError{
+						Message:  err.Error(),
+						Code:     http.StatusBadRequest,
+						Internal: err,
+					}
+				}
+				return httpx.SendJSON(etx, sc.list())
+			},
+		},
+		{
+			Method:   echo.DELETE,
+			Path:     "/schedule/:name",
+			Category: "schedule",
+			Desc: "Remove a power schedule, the ones from the config " +
+				"are back once the monitor restarts",
+			Version: "v1",
+			Handler: func(etx echo.Context) error {
+				if err := sc.remove(etx.Param("name")); err != nil {
+					return &echo.HTTPError{
+						Message:  "schedule not found",
+						Code:     http.StatusNotFound,
+						Internal: err,
+					}
+				}
+				return httpx.SendJSON(etx, sc.list())
+			},
+		},
+	}
+}
@@ -0,0 +1,155 @@
+package mon
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	thermalRoot = "/sys/class/thermal"
+	hwmonRoot   = "/sys/class/hwmon"
+)
+
+// Sensor - a temperature sensor exposed by the kernel either as a thermal
+// zone or through hwmon. Temperature is in degree celsius
+type Sensor struct {
+	Source string  `json:"source"`
+	Device string  `json:"device"`
+	Label  string  `json:"label"`
+	Temp   float64 `json:"temp"`
+}
+
+// Fan - a fan exposed through hwmon
+type Fan struct {
+	Device string `json:"device"`
+	Label  string `json:"label"`
+	RPM    int    `json:"rpm"`
+}
+
+// Names of hwmon devices and thermal zones that report CPU temperature on
+// Raspberry Pis and common x86 boards, in the order of preference
+var cpuSensorNames = []string{
+	"cpu_thermal", "cpu-thermal", "x86_pkg_temp", "coretemp", "k10temp",
+	"zenpower", "soc_thermal",
+}
+
+func readSysfs(path string) (string, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimSpace(string(data)), true
+}
+
+func readSysfsInt(path string) (int64, bool) {
+	str, ok := readSysfs(path)
+	if !ok {
+		return 0, false
+	}
+	val, err := strconv.ParseInt(str, 10, 64)
+	return val, err == nil
+}
+
+// sortedGlob - glob with numeric aware sorting, so that hwmon10 comes after
+// hwmon9
+func sortedGlob(pattern string) []string {
+	matches, _ := filepath.Glob(pattern)
+	sort.Slice(matches, func(i, j int) bool {
+		if len(matches[i]) != len(matches[j]) {
+			return len(matches[i]) < len(matches[j])
+		}
+		return matches[i] < matches[j]
+	})
+	return matches
+}
+
+// readSensors - enumerates all the thermal zones and hwmon sensors. Sensors
+// that can not be read, e.g. a zone of a powered down device, are skipped
+func readSensors() ([]*Sensor, []*Fan) {
+	sensors := make([]*Sensor, 0, 8)
+	fans := make([]*Fan, 0, 2)
+
+	zones := sortedGlob(filepath.Join(thermalRoot, "thermal_zone*"))
+	for _, zone := range zones {
+		milli, ok := readSysfsInt(filepath.Join(zone, "temp"))
+		if !ok {
+			continue
+		}
+		zoneType, _ := readSysfs(filepath.Join(zone, "type"))
+		sensors = append(sensors, &Sensor{
+			Source: "thermal",
+			Device: filepath.Base(zone),
+			Label:  zoneType,
+			Temp:   float64(milli) / 1000,
+		})
+	}
+
+	for _, dev := range sortedGlob(filepath.Join(hwmonRoot, "hwmon*")) {
+		name, _ := readSysfs(filepath.Join(dev, "name"))
+		if name == "" {
+			name = filepath.Base(dev)
+		}
+
+		for _, input := range sortedGlob(filepath.Join(dev, "temp*_input")) {
+			milli, ok := readSysfsInt(input)
+			if !ok {
+				continue
+			}
+			sensors = append(sensors, &Sensor{
+				Source: "hwmon",
+				Device: name,
+				Label:  sensorLabel(input),
+				Temp:   float64(milli) / 1000,
+			})
+		}
+
+		for _, input := range sortedGlob(filepath.Join(dev, "fan*_input")) {
+			rpm, ok := readSysfsInt(input)
+			if !ok {
+				continue
+			}
+			fans = append(fans, &Fan{
+				Device: name,
+				Label:  sensorLabel(input),
+				RPM:    int(rpm),
+			})
+		}
+	}
+	return sensors, fans
+}
+
+// sensorLabel - label of a hwmon input such as temp1_input is in temp1_label,
+// the channel name itself is used when there is no label
+func sensorLabel(input string) string {
+	channel := strings.TrimSuffix(filepath.Base(input), "_input")
+	label, ok := readSysfs(filepath.Join(filepath.Dir(input), channel+"_label"))
+	if !ok || label == "" {
+		return channel
+	}
+	return label
+}
+
+// cpuTemp - picks the sensor most likely to be the CPU temperature and gives
+// its value in milli degree celsius, which is what the older agents sent
+func cpuTemp(sensors []*Sensor) *float64 {
+	for _, name := range cpuSensorNames {
+		for _, sensor := range sensors {
+			if sensor.Label == name || sensor.Device == name {
+				temp := sensor.Temp * 1000
+				return &temp
+			}
+		}
+	}
+	// On boards without a well known sensor, first thermal zone is used like
+	// before
+	for _, sensor := range sensors {
+		if sensor.Source == "thermal" {
+			temp := sensor.Temp * 1000
+			return &temp
+		}
+	}
+	return nil
+}
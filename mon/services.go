@@ -0,0 +1,238 @@
+package mon
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/varunamachi/libx/errx"
+	"github.com/varunamachi/libx/httpx"
+)
+
+var (
+	ErrInvalidUnit          = errors.New("mon.service.invalidUnit")
+	ErrInvalidServiceAction = errors.New("mon.service.invalidAction")
+	ErrSystemctl            = errors.New("mon.service.systemctl")
+)
+
+// Unit names are passed to systemctl, anything that could be taken as an
+// option or glob is rejected
+var unitPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9:_.@\\-]*$`)
+
+type ServiceAction string
+
+const (
+	ServiceStart   ServiceAction = "start"
+	ServiceStop    ServiceAction = "stop"
+	ServiceRestart ServiceAction = "restart"
+)
+
+// UnitStatus - state of a systemd unit as shown by systemctl
+type UnitStatus struct {
+	Unit        string `json:"unit"`
+	Load        string `json:"load"`
+	Active      string `json:"active"`
+	Sub         string `json:"sub"`
+	Description string `json:"description"`
+}
+
+func validateUnit(unit string) error {
+	if !unitPattern.MatchString(unit) {
+		return errx.Errf(ErrInvalidUnit, "invalid unit name '%s'", unit)
+	}
+	return nil
+}
+
+func systemctl(gtx context.Context, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(gtx, "systemctl", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errx.Errf(ErrSystemctl, "systemctl %s failed: %s: %v",
+			args[0], strings.TrimSpace(stderr.String()), err)
+	}
+	return out, nil
+}
+
+// listUnits - gives all the service units known to systemd
+func listUnits(gtx context.Context) ([]*UnitStatus, error) {
+	out, err := systemctl(gtx,
+		"list-units", "--type=service", "--all",
+		"--no-legend", "--no-pager", "--plain")
+	if err != nil {
+		return nil, err
+	}
+
+	units := make([]*UnitStatus, 0, 64)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		// UNIT LOAD ACTIVE SUB DESCRIPTION...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		units = append(units, &UnitStatus{
+			Unit:        fields[0],
+			Load:        fields[1],
+			Active:      fields[2],
+			Sub:         fields[3],
+			Description: strings.Join(fields[4:], " "),
+		})
+	}
+	return units, nil
+}
+
+// unitStatus - gives the state of a single unit. Units that do not exist are
+// reported with load state 'not-found' rather than as an error
+func unitStatus(gtx context.Context, unit string) (*UnitStatus, error) {
+	if err := validateUnit(unit); err != nil {
+		return nil, err
+	}
+	out, err := systemctl(gtx,
+		"show", "--no-pager",
+		"--property=Id,LoadState,ActiveState,SubState,Description",
+		"--", unit)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &UnitStatus{Unit: unit}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		key, val, _ := strings.Cut(scanner.Text(), "=")
+		switch key {
+		case "Id":
+			status.Unit = val
+		case "LoadState":
+			status.Load = val
+		case "ActiveState":
+			status.Active = val
+		case "SubState":
+			status.Sub = val
+		case "Description":
+			status.Description = val
+		}
+	}
+	return status, nil
+}
+
+func controlUnit(
+	gtx context.Context,
+	unit string,
+	action ServiceAction) (*UnitStatus, error) {
+	if err := validateUnit(unit); err != nil {
+		return nil, err
+	}
+	switch action {
+	case ServiceStart, ServiceStop, ServiceRestart:
+	default:
+		return nil, errx.Errf(ErrInvalidServiceAction,
+			"invalid service action '%s', should be one of: "+
+				"start | stop | restart", action)
+	}
+
+	if _, err := systemctl(gtx, string(action), "--", unit); err != nil {
+		return nil, err
+	}
+	return unitStatus(gtx, unit)
+}
+
+func getServiceEndpoints(users []*User) []*httpx.Endpoint {
+	return []*httpx.Endpoint{
+		{
+			Method:   echo.GET,
+			Path:     "/services",
+			Category: "services",
+			Desc:     "List systemd services with their state",
+			Version:  "v0",
+			Handler: func(etx echo.Context) error {
+				units, err := listUnits(etx.Request().Context())
+				if err != nil {
+					return &echo.HTTPError{
+						Code:     http.StatusInternalServerError,
+						Message:  "failed to list services",
+						Internal: err,
+					}
+				}
+				return httpx.SendJSON(etx, units)
+			},
+		},
+		{
+			Method:   echo.GET,
+			Path:     "/services/:unit",
+			Category: "services",
+			Desc:     "Get state of a systemd unit",
+			Version:  "v0",
+			Handler: func(etx echo.Context) error {
+				status, err := unitStatus(
+					etx.Request().Context(), etx.Param("unit"))
+				if err != nil {
+					return serviceError(err)
+				}
+				return httpx.SendJSON(etx, status)
+			},
+		},
+		{
+			Method:   echo.POST,
+			Path:     "/services/:unit/:action",
+			Category: "services",
+			Desc:     "Start, stop or restart a systemd unit",
+			Version:  "v0",
+			Handler: func(etx echo.Context) error {
+				// Control is never allowed on an agent without users, since
+				// it can stop anything running on the node
+				if len(users) == 0 {
+					return &echo.HTTPError{
+						Code: http.StatusForbidden,
+						Message: "service control is disabled since the " +
+							"agent has no users",
+					}
+				}
+				status, err := controlUnit(
+					etx.Request().Context(),
+					etx.Param("unit"),
+					ServiceAction(etx.Param("action")))
+				if err != nil {
+					return serviceError(err)
+				}
+				return httpx.SendJSON(etx, status)
+			},
+		},
+	}
+}
+
+func serviceError(err error) error {
+	if errors.Is(err, ErrInvalidUnit) ||
+		errors.Is(err, ErrInvalidServiceAction) {
+		return &echo.HTTPError{
+			Code:     http.StatusBadRequest,
+			Message:  "invalid unit or action",
+			Internal: err,
+		}
+	}
+	return &echo.HTTPError{
+		Code:     http.StatusInternalServerError,
+		Message:  "failed to run systemctl",
+		Internal: err,
+	}
+}
+
+// GetUnitStatus - gets the state of a systemd unit from an agent
+func GetUnitStatus(
+	gtx context.Context,
+	client *httpx.Client,
+	unit string) (*UnitStatus, error) {
+	status := &UnitStatus{}
+	res := client.Get(gtx, "/api/v0/services", unit)
+	if err := res.LoadClose(status); err != nil {
+		return nil, errx.Errf(err, "failed to get state of unit '%s'", unit)
+	}
+	return status, nil
+}
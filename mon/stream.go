@@ -0,0 +1,303 @@
+package mon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+	"github.com/varunamachi/libx/httpx"
+)
+
+const (
+	streamBufferSize   = 64
+	streamKeepAliveGap = 15 * time.Second
+)
+
+type StreamEventType string
+
+const (
+	// StreamSample - a response from an agent, failed polls included
+	StreamSample StreamEventType = "sample"
+
+	// StreamState - an agent became reachable or unreachable
+	StreamState StreamEventType = "state"
+
+	// StreamRelay - a relay was switched on or off
+	StreamRelay StreamEventType = "relay"
+)
+
+// SampleEvent - response of an agent as seen by the monitor. Time is zero for
+// nodes that have not responded yet
+type SampleEvent struct {
+	Index int       `json:"index"`
+	Node  string    `json:"node"`
+	Up    bool      `json:"up"`
+	Error string    `json:"error,omitempty"`
+	Time  time.Time `json:"time"`
+	Data  *SysInfo  `json:"data,omitempty"`
+}
+
+// StateEvent - reachability of an agent changed from Previous to State
+type StateEvent struct {
+	Index    int        `json:"index"`
+	Node     string     `json:"node"`
+	State    AgentState `json:"state"`
+	Previous AgentState `json:"previous,omitempty"`
+	Error    string     `json:"error,omitempty"`
+	Time     time.Time  `json:"time"`
+}
+
+// streamMsg - an encoded event along with what subscribers filter on
+type streamMsg struct {
+	typ  StreamEventType
+	node string
+	data []byte
+}
+
+// streamFilter - event types and nodes a subscriber is interested in, empty
+// sets match everything
+type streamFilter struct {
+	types map[StreamEventType]bool
+	nodes map[string]bool
+}
+
+func newStreamFilter(types, nodes string) *streamFilter {
+	sf := &streamFilter{
+		types: map[StreamEventType]bool{},
+		nodes: map[string]bool{},
+	}
+	for _, typ := range strings.Split(types, ",") {
+		if typ = strings.TrimSpace(typ); typ != "" {
+			sf.types[StreamEventType(typ)] = true
+		}
+	}
+	for _, node := range strings.Split(nodes, ",") {
+		if node = strings.TrimSpace(node); node != "" {
+			sf.nodes[node] = true
+		}
+	}
+	return sf
+}
+
+func (sf *streamFilter) matches(msg *streamMsg) bool {
+	return (len(sf.types) == 0 || sf.types[msg.typ]) &&
+		(len(sf.nodes) == 0 || sf.nodes[msg.node])
+}
+
+// StreamHandler - fans out samples, agent state changes and relay changes to
+// the clients connected to the stream endpoint as server sent events. Clients
+// that do not keep up miss events rather than holding up the monitor
+type StreamHandler struct {
+	mutex  sync.Mutex
+	subs   map[chan *streamMsg]*streamFilter
+	closed bool
+	latest []*SampleEvent
+	states []AgentState
+}
+
+func NewStreamHandler(agents []*AgentConfig) *StreamHandler {
+	latest := make([]*SampleEvent, len(agents))
+	for idx, agent := range agents {
+		latest[idx] = &SampleEvent{Index: idx, Node: agent.Name}
+	}
+	return &StreamHandler{
+		subs:   make(map[chan *streamMsg]*streamFilter),
+		latest: latest,
+		states: make([]AgentState, len(agents)),
+	}
+}
+
+func (sh *StreamHandler) Handle(
+	gtx context.Context, resp *AgentResponse) error {
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	if resp.Index < 0 || resp.Index >= len(sh.latest) {
+		return nil
+	}
+
+	sample := &SampleEvent{
+		Index: resp.Index,
+		Node:  sh.latest[resp.Index].Node,
+		Up:    resp.Err == nil,
+		Time:  resp.Time,
+		Data:  resp.Data,
+	}
+	state := AgentUp
+	if resp.Err != nil {
+		sample.Error = resp.Err.Error()
+		state = AgentDown
+	}
+	sh.latest[resp.Index] = sample
+
+	if prev := sh.states[resp.Index]; prev != state {
+		sh.states[resp.Index] = state
+		sh.publish(StreamState, sample.Node, &StateEvent{
+			Index:    resp.Index,
+			Node:     sample.Node,
+			State:    state,
+			Previous: prev,
+			Error:    sample.Error,
+			Time:     resp.Time,
+		})
+	}
+	sh.publish(StreamSample, sample.Node, sample)
+	return nil
+}
+
+// SetRelayController - relay changes are streamed along with the samples
+func (sh *StreamHandler) SetRelayController(rc *RelayController) {
+	if rc == nil {
+		return
+	}
+	rc.OnChange(func(change *RelayChange) {
+		sh.mutex.Lock()
+		defer sh.mutex.Unlock()
+		sh.publish(StreamRelay, change.Host, change)
+	})
+}
+
+// Close - ends the streams of all the connected clients
+func (sh *StreamHandler) Close() error {
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	sh.closed = true
+	for ch := range sh.subs {
+		close(ch)
+		delete(sh.subs, ch)
+	}
+	return nil
+}
+
+// publish - lock should be held by the caller
+func (sh *StreamHandler) publish(
+	typ StreamEventType, node string, event any) {
+	if len(sh.subs) == 0 {
+		return
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Error().Err(err).Str("event", string(typ)).
+			Msg("failed to encode stream event")
+		return
+	}
+
+	msg := &streamMsg{typ: typ, node: node, data: data}
+	for ch, filter := range sh.subs {
+		if !filter.matches(msg) {
+			continue
+		}
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+}
+
+// subscribe - gives the channel for the events along with the last sample of
+// every node, nil channel if the handler is closed
+func (sh *StreamHandler) subscribe(
+	filter *streamFilter) (chan *streamMsg, []*streamMsg) {
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	if sh.closed {
+		return nil, nil
+	}
+	initial := make([]*streamMsg, 0, len(sh.latest))
+	for _, sample := range sh.latest {
+		data, err := json.Marshal(sample)
+		if err != nil {
+			continue
+		}
+		msg := &streamMsg{typ: StreamSample, node: sample.Node, data: data}
+		if filter.matches(msg) {
+			initial = append(initial, msg)
+		}
+	}
+
+	ch := make(chan *streamMsg, streamBufferSize)
+	sh.subs[ch] = filter
+	return ch, initial
+}
+
+func (sh *StreamHandler) unsubscribe(ch chan *streamMsg) {
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+	delete(sh.subs, ch)
+}
+
+// serve - streams the events until the client goes away or the handler is
+// closed, starting with the last sample of every node
+func (sh *StreamHandler) serve(etx echo.Context) error {
+	filter := newStreamFilter(
+		etx.QueryParam("events"), etx.QueryParam("nodes"))
+	ch, initial := sh.subscribe(filter)
+	if ch == nil {
+		return &echo.HTTPError{
+			Code:    http.StatusServiceUnavailable,
+			Message: "monitor is shutting down",
+		}
+	}
+	defer sh.unsubscribe(ch)
+
+	res := etx.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set(echo.HeaderConnection, "keep-alive")
+	res.WriteHeader(http.StatusOK)
+
+	write := func(msg *streamMsg) error {
+		_, err := fmt.Fprintf(res, "event: %s\ndata: %s\n\n", msg.typ, msg.data)
+		return err
+	}
+	for _, msg := range initial {
+		if err := write(msg); err != nil {
+			return nil
+		}
+	}
+	res.Flush()
+
+	ticker := time.NewTicker(streamKeepAliveGap)
+	defer ticker.Stop()
+	for {
+		var err error
+		select {
+		case <-etx.Request().Context().Done():
+			return nil
+		case <-ticker.C:
+			_, err = res.Write([]byte(": keep-alive\n\n"))
+		case msg, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			err = write(msg)
+		}
+		if err != nil {
+			// Client is gone, nothing to report
+			return nil
+		}
+		res.Flush()
+	}
+}
+
+func getStreamEndpoints(sh *StreamHandler) []*httpx.Endpoint {
+	return []*httpx.Endpoint{
+		{
+			Method:   echo.GET,
+			Path:     "/stream",
+			Category: "stream",
+			Desc: "Stream samples, agent state and relay changes as " +
+				"server sent events, query params: events and nodes",
+			Version: "v1",
+			Handler: sh.serve,
+		},
+	}
+}
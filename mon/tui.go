@@ -3,17 +3,266 @@ package mon
 import (
 	"context"
 	"fmt"
+	"math"
 	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	ui "github.com/gizak/termui/v3"
 	"github.com/gizak/termui/v3/widgets"
+	"github.com/rs/zerolog/log"
 	"github.com/varunamachi/libx/errx"
+	"github.com/varunamachi/libx/httpx"
 )
 
+const (
+	procsTimeout = 5 * time.Second
+
+	// Number of samples kept per node for the trends
+	trendSize = 240
+	// Width of the CPU trend in the node table
+	trendColumnWidth = 20
+
+	statusHeight = 1
+)
+
+type tuiView int
+
+const (
+	viewNodes tuiView = iota
+	viewDetail
+	viewProcs
+)
+
+// tuiColumn - a column of the node table. Value is used for sorting and
+// coloring, NaN when the node did not report it
+type tuiColumn struct {
+	title string
+	width int
+	value func(info *SysInfo) float64
+	text  func(info *SysInfo) string
+	warn  float64
+	crit  float64
+}
+
+func pctText(val float64) string {
+	return fmt.Sprintf("%.1f%%", val)
+}
+
+var tuiColumns = []*tuiColumn{
+	{
+		title: "Name",
+		width: 14,
+	},
+	{
+		title: "Temp",
+		width: 9,
+		value: func(info *SysInfo) float64 {
+			if info.CPUTemp == nil {
+				return math.NaN()
+			}
+			return *info.CPUTemp / 1000
+		},
+		text: func(info *SysInfo) string { return info.FormatTemp() },
+		warn: 70,
+		crit: 80,
+	},
+	{
+		title: "CPU",
+		width: 8,
+		value: func(info *SysInfo) float64 { return info.CPUUsagePct },
+		text:  func(info *SysInfo) string { return pctText(info.CPUUsagePct) },
+		warn:  75,
+		crit:  90,
+	},
+	{
+		title: "RAM",
+		width: 8,
+		value: func(info *SysInfo) float64 { return info.MemUsagePct },
+		text:  func(info *SysInfo) string { return pctText(info.MemUsagePct) },
+		warn:  75,
+		crit:  90,
+	},
+	{
+		title: "Swap",
+		width: 8,
+		value: func(info *SysInfo) float64 {
+			if info.Version < 1 {
+				return math.NaN()
+			}
+			return info.SwapUsagePct
+		},
+		text: func(info *SysInfo) string { return pctText(info.SwapUsagePct) },
+		warn: 50,
+		crit: 80,
+	},
+	{
+		title: "Load",
+		width: 7,
+		value: func(info *SysInfo) float64 {
+			if info.Load == nil {
+				return math.NaN()
+			}
+			return info.Load.Load1
+		},
+		text: func(info *SysInfo) string {
+			return fmt.Sprintf("%.2f", info.Load.Load1)
+		},
+		warn: 4,
+		crit: 8,
+	},
+	{
+		title: "Disk",
+		width: 8,
+		value: func(info *SysInfo) float64 {
+			if info.Version < 1 {
+				return math.NaN()
+			}
+			return info.MaxDiskPct()
+		},
+		text: func(info *SysInfo) string { return pctText(info.MaxDiskPct()) },
+		warn: 80,
+		crit: 95,
+	},
+	{
+		title: "Net Rx/Tx",
+		width: 16,
+		value: func(info *SysInfo) float64 {
+			if info.Version < 1 {
+				return math.NaN()
+			}
+			rx, tx := info.NetTotals()
+			return rx + tx
+		},
+		text: func(info *SysInfo) string {
+			rx, tx := info.NetTotals()
+			return humanRate(rx) + "/" + humanRate(tx)
+		},
+		warn: math.Inf(1),
+		crit: math.Inf(1),
+	},
+	{
+		title: "Flags",
+		width: 9,
+	},
+	{
+		title: "CPU Trend",
+		width: trendColumnWidth + 2,
+	},
+}
+
+func (col *tuiColumn) cell(info *SysInfo) string {
+	if info == nil || col.value == nil {
+		return "N/A"
+	}
+	val := col.value(info)
+	if math.IsNaN(val) {
+		return "N/A"
+	}
+	text := col.text(info)
+	switch {
+	case val >= col.crit:
+		return "[" + text + "](fg:red,mod:bold)"
+	case val >= col.warn:
+		return "[" + text + "](fg:yellow)"
+	}
+	return "[" + text + "](fg:green)"
+}
+
+// nodeTrend - last samples of a node, oldest first
+type nodeTrend struct {
+	cpu  []float64
+	mem  []float64
+	temp []float64
+}
+
+func appendCapped(vals []float64, val float64) []float64 {
+	vals = append(vals, val)
+	if len(vals) > trendSize {
+		vals = vals[len(vals)-trendSize:]
+	}
+	return vals
+}
+
+func (nt *nodeTrend) add(info *SysInfo) {
+	temp := 0.0
+	if info.CPUTemp != nil {
+		temp = *info.CPUTemp / 1000
+	}
+	nt.cpu = appendCapped(nt.cpu, info.CPUUsagePct)
+	nt.mem = appendCapped(nt.mem, info.MemUsagePct)
+	nt.temp = appendCapped(nt.temp, temp)
+}
+
+var sparkRunes = []rune("▁▂▃▄▅▆▇█")
+
+// textSparkline - last width values scaled to 0..maxVal as block characters
+func textSparkline(vals []float64, width int, maxVal float64) string {
+	if len(vals) > width {
+		vals = vals[len(vals)-width:]
+	}
+	var sb strings.Builder
+	for _, val := range vals {
+		idx := int(val / maxVal * float64(len(sparkRunes)-1))
+		idx = max(0, min(idx, len(sparkRunes)-1))
+		sb.WriteRune(sparkRunes[idx])
+	}
+	return sb.String()
+}
+
+// lastN - last count values, so that sparklines show the recent samples
+func lastN(vals []float64, count int) []float64 {
+	if count < 0 {
+		count = 0
+	}
+	if len(vals) > count {
+		return vals[len(vals)-count:]
+	}
+	return vals
+}
+
+// pendingToggle - relay switch waiting for the confirmation of the user
+type pendingToggle struct {
+	slot int
+	node string
+	on   bool
+}
+
 type TuiHandler struct {
-	cfg    *Config
+	mutex    sync.Mutex
+	cfg      *Config
+	relayCtl *RelayController
+	width    int
+	height   int
+	view     tuiView
+
 	table  *widgets.Table
-	values []*SysInfo
+	sparks *widgets.SparklineGroup
+	detail *widgets.Paragraph
+	status *widgets.Paragraph
+
+	values   []*SysInfo
+	down     []bool
+	trends   []*nodeTrend
+	selected int // index of the selected agent, not of the row
+
+	sortCol  int
+	sortDesc bool
+
+	// Detail view of the selected node
+	hostInfo *HostInfo
+	hostErr  error
+
+	// Process drill down for the selected node
+	procs      *widgets.Table
+	procsByMem bool
+	procsData  *TopProcs
+	clients    []*httpx.Client
+
+	confirm *pendingToggle
+	message string
 }
 
 func NewTuiHandler(cfg *Config) (Handler, context.Context, error) {
@@ -22,76 +271,663 @@ func NewTuiHandler(cfg *Config) (Handler, context.Context, error) {
 
 	}
 
-	table := widgets.NewTable()
-	table.RowStyles[0] = ui.NewStyle(
-		ui.ColorWhite, ui.ColorBlack, ui.ModifierBold)
+	count := len(cfg.AgentConfig)
+	hdl := &TuiHandler{
+		cfg:     cfg,
+		table:   widgets.NewTable(),
+		sparks:  widgets.NewSparklineGroup(),
+		detail:  widgets.NewParagraph(),
+		status:  widgets.NewParagraph(),
+		values:  make([]*SysInfo, count),
+		down:    make([]bool, count),
+		trends:  make([]*nodeTrend, count),
+		clients: make([]*httpx.Client, count),
+	}
+	for idx := range hdl.trends {
+		hdl.trends[idx] = &nodeTrend{}
+	}
+
+	hdl.table.RowSeparator = false
+	hdl.table.TextStyle = ui.NewStyle(ui.ColorWhite)
+	hdl.table.TextAlignment = ui.AlignLeft
+	hdl.table.Title = "Nodes"
+	hdl.detail.WrapText = false
+	hdl.status.Border = false
 
-	table.TextStyle = ui.NewStyle(ui.ColorWhite)
-	table.SetRect(0, 0, cfg.Width, cfg.Height)
-	table.TextAlignment = ui.AlignCenter
-	table.Rows = make([][]string, len(cfg.AgentConfig)+1)
+	hdl.width, hdl.height = ui.TerminalDimensions()
+	if hdl.width <= 0 || hdl.height <= 0 {
+		hdl.width, hdl.height = cfg.Width, cfg.Height
+	}
 	uiEvents := ui.PollEvents()
 
 	gtx, cancel := context.WithCancel(context.Background())
 	go func() {
 		for {
 			e := <-uiEvents
+			if e.ID == "<C-c>" {
+				fmt.Println("Terminating application")
+				os.Exit(1)
+			}
+			if e.ID == "<Resize>" {
+				payload := e.Payload.(ui.Resize)
+				hdl.resize(payload.Width, payload.Height)
+				continue
+			}
+			if hdl.handleConfirmation(e.ID) {
+				continue
+			}
 			switch e.ID {
 			case "q":
 				cancel()
-			case "<C-c>":
-				// ui.Clear()
-				fmt.Println("Terminating application")
-				os.Exit(1)
+			case "<Down>", "j":
+				hdl.moveSelection(1)
+			case "<Up>", "k":
+				hdl.moveSelection(-1)
+			case "<Enter>", "d":
+				hdl.showDetail(gtx)
+			case "p", "r":
+				hdl.showProcs(gtx)
+			case "m":
+				hdl.toggleProcSort()
+			case "s":
+				hdl.cycleSort()
+			case "S":
+				hdl.reverseSort()
+			case "t":
+				hdl.askToggle()
+			case "<Escape>", "b":
+				hdl.back()
 			}
-
 		}
 	}()
 
-	hdl := &TuiHandler{
-		cfg:    cfg,
-		table:  table,
-		values: make([]*SysInfo, len(cfg.AgentConfig)),
-	}
 	return hdl, gtx, nil
 }
 
+func (t *TuiHandler) SetRelayController(rc *RelayController) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.relayCtl = rc
+}
+
+// OwnsConsole - the TUI draws over the whole terminal
+func (t *TuiHandler) OwnsConsole() bool {
+	return true
+}
+
 func (t *TuiHandler) Close() error {
 	ui.Close()
 	return nil
 }
 
 func (t *TuiHandler) Handle(gtx context.Context, resp *AgentResponse) error {
+	select {
+	case <-gtx.Done():
+		return gtx.Err()
+	default:
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
 
 	t.values[resp.Index] = resp.Data
-	t.table.Rows[0] = []string{"Name", "Temp", "CPU Usage", "RAM Usage"}
+	t.down[resp.Index] = resp.Err != nil
+	if resp.Data != nil {
+		t.trends[resp.Index].add(resp.Data)
+	}
+	if t.view != viewProcs {
+		t.render()
+	}
+	return nil
+}
+
+func (t *TuiHandler) resize(width, height int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.width, t.height = width, height
+	ui.Clear()
+	t.render()
+}
+
+// order - indices of the agents in the order they are shown. Nodes that did
+// not report the value of the sort column go to the end
+func (t *TuiHandler) order() []int {
+	order := make([]int, len(t.cfg.AgentConfig))
+	for idx := range order {
+		order[idx] = idx
+	}
+
+	col := tuiColumns[t.sortCol]
+	value := func(idx int) float64 {
+		if t.values[idx] == nil {
+			return math.NaN()
+		}
+		return col.value(t.values[idx])
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		if col.value == nil {
+			ni := t.cfg.AgentConfig[order[i]].Name
+			nj := t.cfg.AgentConfig[order[j]].Name
+			if t.sortDesc {
+				return ni > nj
+			}
+			return ni < nj
+		}
 
-	for index, ag := range t.cfg.AgentConfig {
+		vi, vj := value(order[i]), value(order[j])
+		if math.IsNaN(vi) || math.IsNaN(vj) {
+			return !math.IsNaN(vi) && math.IsNaN(vj)
+		}
+		if t.sortDesc {
+			return vi > vj
+		}
+		return vi < vj
+	})
+	return order
+}
 
-		select {
-		case <-gtx.Done():
-			fmt.Println("D:TUI")
-			return gtx.Err()
-		default:
+// render - draws the current view, lock should be held by the caller
+func (t *TuiHandler) render() {
+	t.renderStatus()
+	switch t.view {
+	case viewProcs:
+		t.procs.SetRect(0, 0, t.width, t.height-statusHeight)
+		ui.Render(t.procs, t.status)
+	case viewDetail:
+		t.renderDetail()
+	default:
+		t.renderNodes()
+	}
+}
+
+func (t *TuiHandler) renderNodes() {
+	header := make([]string, len(tuiColumns))
+	widths := make([]int, len(tuiColumns))
+	for idx, col := range tuiColumns {
+		header[idx] = col.title
+		if idx == t.sortCol && t.sortDesc {
+			header[idx] += " ▼"
+		} else if idx == t.sortCol {
+			header[idx] += " ▲"
+		}
+		widths[idx] = col.width
+	}
+	t.table.ColumnWidths = widths
+	t.table.Rows = [][]string{header}
+	for key := range t.table.RowStyles {
+		delete(t.table.RowStyles, key)
+	}
+	t.table.RowStyles[0] = ui.NewStyle(
+		ui.ColorWhite, ui.ColorClear, ui.ModifierBold)
 
+	for _, idx := range t.order() {
+		ag := t.cfg.AgentConfig[idx]
+		info := t.values[idx]
+		row := make([]string, len(tuiColumns))
+		row[0] = ag.Name
+		if t.down[idx] {
+			row[0] = "[" + ag.Name + "](fg:red)"
 		}
-		val := t.values[index]
-		if val == nil {
-			t.table.Rows[index+1] = []string{
-				ag.Name,
-				"N/A",
-				"N/A",
-				"N/A",
+		for cidx, col := range tuiColumns[1 : len(tuiColumns)-2] {
+			row[cidx+1] = col.cell(info)
+		}
+		if info != nil {
+			row[len(row)-2] = info.Throttling.Flags()
+		}
+		row[len(row)-1] = textSparkline(
+			t.trends[idx].cpu, trendColumnWidth, 100)
+
+		if idx == t.selected {
+			// Cell colors would hide the selection, hence plain text
+			row[0] = "> " + ag.Name
+			t.table.RowStyles[len(t.table.Rows)] = ui.NewStyle(
+				ui.ColorBlack, ui.ColorWhite)
+			for cidx, cell := range row {
+				row[cidx] = plainText(cell)
 			}
+		}
+		t.table.Rows = append(t.table.Rows, row)
+	}
+
+	tableHeight := min(len(t.table.Rows)+2, t.height-statusHeight-6)
+	t.table.SetRect(0, 0, t.width, max(tableHeight, 3))
+
+	t.fillSparks()
+	t.sparks.SetRect(0, max(tableHeight, 3), t.width, t.height-statusHeight)
+	ui.Render(t.table, t.sparks, t.status)
+}
+
+// plainText - strips the style markup of termui from a cell
+func plainText(cell string) string {
+	if strings.HasPrefix(cell, "[") {
+		if end := strings.Index(cell, "]("); end > 0 {
+			return cell[1:end]
+		}
+	}
+	return cell
+}
+
+// fillSparks - sparklines of the selected node
+func (t *TuiHandler) fillSparks() {
+	if len(t.cfg.AgentConfig) == 0 {
+		return
+	}
+	trend := t.trends[t.selected]
+	width := t.sparks.Inner.Dx()
+	if width <= 0 {
+		width = t.width - 2
+	}
+
+	cpu := widgets.NewSparkline()
+	cpu.Title = "CPU %"
+	cpu.Data = lastN(trend.cpu, width)
+	cpu.MaxVal = 100
+	cpu.LineColor = ui.ColorGreen
+
+	mem := widgets.NewSparkline()
+	mem.Title = "Memory %"
+	mem.Data = lastN(trend.mem, width)
+	mem.MaxVal = 100
+	mem.LineColor = ui.ColorCyan
+
+	temp := widgets.NewSparkline()
+	temp.Title = "Temperature °C"
+	temp.Data = lastN(trend.temp, width)
+	temp.MaxVal = 100
+	temp.LineColor = ui.ColorYellow
+
+	t.sparks.Sparklines = []*widgets.Sparkline{cpu, mem, temp}
+	t.sparks.Title = t.cfg.AgentConfig[t.selected].Name + " - recent samples"
+}
+
+func (t *TuiHandler) renderDetail() {
+	ag := t.cfg.AgentConfig[t.selected]
+	info := t.values[t.selected]
+
+	var sb strings.Builder
+	switch {
+	case t.hostErr != nil:
+		fmt.Fprintf(&sb, "Host information not available: %v\n", t.hostErr)
+	case t.hostInfo == nil:
+		sb.WriteString("Loading host information...\n")
+	default:
+		hi := t.hostInfo
+		fmt.Fprintf(&sb, "Hostname:  %s\n", hi.Hostname)
+		fmt.Fprintf(&sb, "Platform:  %s %s (%s)\n",
+			hi.Platform, hi.PlatformVersion, hi.OS)
+		fmt.Fprintf(&sb, "Kernel:    %s %s\n", hi.KernelVersion, hi.KernelArch)
+		fmt.Fprintf(&sb, "Uptime:    %s\n",
+			humanDuration(time.Duration(hi.Uptime)*time.Second))
+		fmt.Fprintf(&sb, "Processes: %d\n", hi.Procs)
+	}
+	fmt.Fprintf(&sb, "Address:   %s\n\n", ag.Address)
+
+	if info == nil {
+		sb.WriteString("No samples from the agent\n")
+	} else {
+		fmt.Fprintf(&sb, "Temp %s  CPU %.1f%%  RAM %.1f%%  Swap %.1f%%\n",
+			info.FormatTemp(), info.CPUUsagePct, info.MemUsagePct,
+			info.SwapUsagePct)
+		if info.Load != nil {
+			fmt.Fprintf(&sb, "Load %.2f %.2f %.2f\n",
+				info.Load.Load1, info.Load.Load5, info.Load.Load15)
+		}
+		if flags := info.Throttling.Flags(); flags != "" {
+			fmt.Fprintf(&sb, "Throttling: %s\n", flags)
+		}
+		for _, du := range info.Disks {
+			fmt.Fprintf(&sb, "Disk %-20s %5.1f%%\n", du.Mount, du.UsedPct)
+		}
+		for _, nr := range info.Net {
+			fmt.Fprintf(&sb, "Net  %-20s %s/%s\n",
+				nr.Iface, humanRate(nr.RxRate), humanRate(nr.TxRate))
+		}
+		for _, sn := range info.Sensors {
+			fmt.Fprintf(&sb, "Sensor %s %s: %.1f°C\n",
+				sn.Device, sn.Label, sn.Temp)
+		}
+		for _, fn := range info.Fans {
+			fmt.Fprintf(&sb, "Fan %s %s: %d RPM\n", fn.Device, fn.Label, fn.RPM)
+		}
+	}
+
+	t.detail.Title = ag.Name
+	t.detail.Text = sb.String()
+	half := t.width / 2
+	t.detail.SetRect(0, 0, half, t.height-statusHeight)
+	t.sparks.SetRect(half, 0, t.width, t.height-statusHeight)
+	t.fillSparks()
+	ui.Render(t.detail, t.sparks, t.status)
+}
+
+func (t *TuiHandler) renderStatus() {
+	t.status.SetRect(0, t.height-statusHeight, t.width, t.height)
+	switch {
+	case t.confirm != nil:
+		state := "OFF"
+		if !t.confirm.on {
+			state = "ON"
+		}
+		t.status.Text = fmt.Sprintf(
+			"[Turn %s power of %s? y/n](fg:black,bg:yellow)",
+			state, t.confirm.node)
+		return
+	case t.message != "":
+		t.status.Text = t.message
+		return
+	}
+
+	switch t.view {
+	case viewProcs:
+		t.status.Text = "m: sort by CPU/memory  r: refresh  b: back  q: quit"
+	case viewDetail:
+		t.status.Text = "p: processes  t: toggle power  b: back  q: quit"
+	default:
+		t.status.Text = "↑/↓: select  s/S: sort column/order  " +
+			"enter: details  p: processes  t: toggle power  q: quit"
+	}
+}
+
+func (t *TuiHandler) moveSelection(delta int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.view != viewNodes || len(t.cfg.AgentConfig) == 0 {
+		return
+	}
+	order := t.order()
+	pos := 0
+	for idx, agentIdx := range order {
+		if agentIdx == t.selected {
+			pos = idx
+		}
+	}
+	count := len(order)
+	t.selected = order[(pos+delta+count)%count]
+	t.message = ""
+	t.render()
+}
+
+func (t *TuiHandler) cycleSort() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.view != viewNodes {
+		return
+	}
+	// Only the name and the columns with values are sortable
+	for {
+		t.sortCol = (t.sortCol + 1) % len(tuiColumns)
+		if t.sortCol == 0 || tuiColumns[t.sortCol].value != nil {
+			break
+		}
+	}
+	t.render()
+}
+
+func (t *TuiHandler) reverseSort() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.view != viewNodes {
+		return
+	}
+	t.sortDesc = !t.sortDesc
+	t.render()
+}
+
+func (t *TuiHandler) back() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	switch t.view {
+	case viewProcs:
+		t.procs = nil
+		t.procsData = nil
+		t.view = viewNodes
+	case viewDetail:
+		t.view = viewNodes
+	}
+	t.message = ""
+	ui.Clear()
+	t.render()
+}
+
+// askToggle - asks the user to confirm switching the relay of the selected
+// node, the switch happens in handleConfirmation
+func (t *TuiHandler) askToggle() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if len(t.cfg.AgentConfig) == 0 {
+		return
+	}
+	node := t.cfg.AgentConfig[t.selected].Name
+	defer t.render()
+
+	if t.relayCtl == nil {
+		t.message = "[Relays are not available](fg:red)"
+		return
+	}
+	slot, err := t.relayCtl.SlotOf(node)
+	if err != nil {
+		t.message = fmt.Sprintf("[No relay for %s](fg:red)", node)
+		return
+	}
+	states, err := t.relayCtl.GetStates()
+	if err != nil {
+		t.message = "[Failed to get relay states](fg:red)"
+		return
+	}
+	t.confirm = &pendingToggle{slot: slot, node: node, on: states[slot]}
+}
+
+// handleConfirmation - consumes the key if a confirmation is pending, 'y'
+// switches the relay and any other key cancels
+func (t *TuiHandler) handleConfirmation(key string) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.confirm == nil {
+		return false
+	}
+	pending := t.confirm
+	t.confirm = nil
+	t.message = "Cancelled"
+	if key == "y" || key == "Y" {
+		on, err := t.relayCtl.Switch(pending.slot, SwitchToggle)
+		if err != nil {
+			log.Error().Err(err).Str("node", pending.node).
+				Msg("failed to toggle relay")
+			t.message = fmt.Sprintf(
+				"[Failed to switch power of %s](fg:red)", pending.node)
 		} else {
-			t.table.Rows[index+1] = []string{
-				ag.Name,
-				fmt.Sprintf("%.2f", val.CPUTemp/1000),
-				fmt.Sprintf("%.2f%%", val.CPUUsagePct),
-				fmt.Sprintf("%.2f%%", val.MemUsagePct),
+			state := "OFF"
+			if on {
+				state = "ON"
 			}
+			t.message = fmt.Sprintf("Power of %s is %s", pending.node, state)
 		}
-		ui.Render(t.table)
 	}
-	return nil
+	t.render()
+	return true
+}
+
+// client - client for the agent at the index, created on first use. The
+// polling clients time out too quickly for process sampling
+func (t *TuiHandler) client(
+	gtx context.Context, index int) (*httpx.Client, error) {
+	t.mutex.Lock()
+	client := t.clients[index]
+	t.mutex.Unlock()
+	if client != nil {
+		return client, nil
+	}
+
+	client, err := NewAgentClient(gtx, t.cfg.AgentConfig[index], procsTimeout)
+	if err != nil {
+		return nil, err
+	}
+	t.mutex.Lock()
+	t.clients[index] = client
+	t.mutex.Unlock()
+	return client, nil
+}
+
+// showDetail - shows host information and trends of the selected node
+func (t *TuiHandler) showDetail(gtx context.Context) {
+	t.mutex.Lock()
+	if len(t.cfg.AgentConfig) == 0 {
+		t.mutex.Unlock()
+		return
+	}
+	index := t.selected
+	t.view = viewDetail
+	t.hostInfo, t.hostErr = nil, nil
+	t.message = ""
+	ui.Clear()
+	t.render()
+	t.mutex.Unlock()
+
+	go func() {
+		var info *HostInfo
+		client, err := t.client(gtx, index)
+		if err == nil {
+			info, err = GetHostInfo(gtx, client)
+		}
+
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		if t.view != viewDetail || t.selected != index {
+			return
+		}
+		if err != nil {
+			log.Debug().Err(err).Msg("failed to get host information")
+			t.hostErr = err
+		}
+		t.hostInfo = info
+		t.render()
+	}()
+}
+
+func (t *TuiHandler) toggleProcSort() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.view != viewProcs {
+		return
+	}
+	t.procsByMem = !t.procsByMem
+	t.fillProcs()
+	t.render()
+}
+
+// showProcs - fetches the top processes of the selected node from its agent
+// and shows them in place of the other views
+func (t *TuiHandler) showProcs(gtx context.Context) {
+	t.mutex.Lock()
+	if len(t.cfg.AgentConfig) == 0 {
+		t.mutex.Unlock()
+		return
+	}
+	index := t.selected
+	if t.procs == nil {
+		t.procs = widgets.NewTable()
+		t.procs.TextStyle = ui.NewStyle(ui.ColorWhite)
+		t.procs.RowStyles[0] = ui.NewStyle(
+			ui.ColorWhite, ui.ColorBlack, ui.ModifierBold)
+	}
+	t.procs.ColumnWidths = procColumnWidths(t.width)
+	t.procs.Title = t.cfg.AgentConfig[index].Name + " - loading..."
+	t.view = viewProcs
+	t.message = ""
+	t.fillProcs()
+	ui.Clear()
+	t.render()
+	t.mutex.Unlock()
+
+	go func() {
+		var top *TopProcs
+		client, err := t.client(gtx, index)
+		if err == nil {
+			top, err = GetTopProcs(gtx, client)
+		}
+
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		if t.view != viewProcs || t.selected != index {
+			return
+		}
+		if err != nil {
+			log.Debug().Err(err).Msg("failed to get processes")
+			t.procs.Title = t.cfg.AgentConfig[index].Name +
+				" - failed to get processes"
+		} else {
+			t.procsData = top
+		}
+		t.fillProcs()
+		t.render()
+	}()
+}
+
+// fillProcs - fills the process table from the last fetched data, lock
+// should be held by the caller
+func (t *TuiHandler) fillProcs() {
+	t.procs.Rows = [][]string{
+		{"PID", "User", "CPU", "Mem", "Runtime", "Command"},
+	}
+	if t.procsData == nil {
+		t.procs.Rows = append(t.procs.Rows, []string{"", "", "", "", "", ""})
+		return
+	}
+
+	procs, sortedBy := t.procsData.ByCPU, "CPU"
+	if t.procsByMem {
+		procs, sortedBy = t.procsData.ByMem, "memory"
+	}
+	t.procs.Title = fmt.Sprintf("%s - top processes by %s",
+		t.cfg.AgentConfig[t.selected].Name, sortedBy)
+
+	for _, proc := range procs {
+		cmd := proc.Cmdline
+		if cmd == "" {
+			cmd = proc.Name
+		}
+		t.procs.Rows = append(t.procs.Rows, []string{
+			fmt.Sprint(proc.Pid),
+			proc.User,
+			fmt.Sprintf("%.1f%%", proc.CPUUsagePct),
+			fmt.Sprintf("%.1f%%", proc.MemUsagePct),
+			humanDuration(time.Duration(proc.RuntimeSecs) * time.Second),
+			cmd,
+		})
+	}
+}
+
+func procColumnWidths(width int) []int {
+	widths := []int{8, 10, 8, 8, 12}
+	rest := width - 2
+	for _, w := range widths {
+		rest -= w
+	}
+	return append(widths, max(rest, 10))
+}
+
+// humanDuration - compact form of a duration like 3d4h or 12m5s
+func humanDuration(dur time.Duration) string {
+	days := int(dur.Hours()) / 24
+	hours := int(dur.Hours()) % 24
+	minutes := int(dur.Minutes()) % 60
+	seconds := int(dur.Seconds()) % 60
+	var sb strings.Builder
+	switch {
+	case days > 0:
+		fmt.Fprintf(&sb, "%dd%dh", days, hours)
+	case hours > 0:
+		fmt.Fprintf(&sb, "%dh%dm", hours, minutes)
+	default:
+		fmt.Fprintf(&sb, "%dm%ds", minutes, seconds)
+	}
+	return sb.String()
 }
@@ -0,0 +1,265 @@
+package mon
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// WatchdogConfig - a node whose agent has not responded for UnresponsiveSecs
+// and whose SSH server does not answer either, is power cycled through
+// its relay. After each attempt the watchdog waits for BackoffSecs before
+// trying again, doubling the wait each time, and gives up after MaxAttempts
+type WatchdogConfig struct {
+	Enabled          bool `json:"enabled"`
+	UnresponsiveSecs int  `json:"unresponsiveSecs"`
+	MaxAttempts      int  `json:"maxAttempts"`
+	BackoffSecs      int  `json:"backoffSecs"`
+	CycleDelaySecs   int  `json:"cycleDelaySecs"`
+}
+
+func (wc *WatchdogConfig) fillDefaults() {
+	if wc.UnresponsiveSecs <= 0 {
+		wc.UnresponsiveSecs = 5 * 60
+	}
+	if wc.MaxAttempts <= 0 {
+		wc.MaxAttempts = 3
+	}
+	if wc.BackoffSecs <= 0 {
+		wc.BackoffSecs = 5 * 60
+	}
+	if wc.CycleDelaySecs <= 0 {
+		wc.CycleDelaySecs = int(DefaultCycleDelay.Seconds())
+	}
+}
+
+// maxWatchdogBackoff - upper limit for the wait between power cycles, the
+// doubling would overflow with a large MaxAttempts otherwise
+const maxWatchdogBackoff = 24 * time.Hour
+
+// sshProbeTimeout - time given to the SSH server of a node to connect and
+// send its version banner
+const sshProbeTimeout = 10 * time.Second
+
+type watchState struct {
+	lastSeen    time.Time
+	attempts    int
+	nextAttempt time.Time
+	gaveUp      bool
+	sshAlive    bool
+}
+
+// watchdog - responses only update the state of the nodes, the checks that
+// involve the network run in a loop of their own so that they do not hold up
+// the consumers of the responses
+type watchdog struct {
+	mutex    sync.Mutex
+	cfg      *WatchdogConfig
+	agents   []*AgentConfig
+	relayCtl *RelayController
+	events   *EventLog
+	states   []*watchState
+}
+
+func newWatchdog(
+	cfg *WatchdogConfig,
+	agents []*AgentConfig,
+	relayCtl *RelayController,
+	events *EventLog) *watchdog {
+	if cfg == nil || !cfg.Enabled {
+		return nil
+	}
+	if relayCtl == nil {
+		log.Warn().Msg("relays are not available, disabling watchdog")
+		return nil
+	}
+	cfg.fillDefaults()
+
+	now := time.Now()
+	states := make([]*watchState, len(agents))
+	for idx := range agents {
+		states[idx] = &watchState{lastSeen: now}
+	}
+	return &watchdog{
+		cfg:      cfg,
+		agents:   agents,
+		relayCtl: relayCtl,
+		events:   events,
+		states:   states,
+	}
+}
+
+// observe - records a successful response from an agent
+func (wd *watchdog) observe(resp *AgentResponse) {
+	if wd == nil || resp.Err != nil ||
+		resp.Index < 0 || resp.Index >= len(wd.states) {
+		return
+	}
+
+	wd.mutex.Lock()
+	defer wd.mutex.Unlock()
+	state := wd.states[resp.Index]
+	if state.attempts != 0 || state.gaveUp {
+		wd.events.Add(EventWatchdog, wd.agents[resp.Index].Name,
+			"agent is responding again after %d power cycles",
+			state.attempts)
+	}
+	*state = watchState{lastSeen: time.Now()}
+}
+
+// run - checks the nodes every second until the context is done
+func (wd *watchdog) run(gtx context.Context) error {
+	if wd == nil {
+		return nil
+	}
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-gtx.Done():
+			return gtx.Err()
+		case <-ticker.C:
+		}
+		for idx := range wd.agents {
+			if wd.due(idx) {
+				wd.check(idx)
+			}
+		}
+	}
+}
+
+// due - whether the node has been unresponsive for long enough and is not
+// waiting for its backoff to pass
+func (wd *watchdog) due(idx int) bool {
+	wd.mutex.Lock()
+	defer wd.mutex.Unlock()
+
+	state := wd.states[idx]
+	now := time.Now()
+	unresponsive := time.Duration(wd.cfg.UnresponsiveSecs) * time.Second
+	if state.gaveUp ||
+		now.Sub(state.lastSeen) < unresponsive ||
+		now.Before(state.nextAttempt) {
+		return false
+	}
+
+	if state.attempts >= wd.cfg.MaxAttempts {
+		state.gaveUp = true
+		wd.events.Add(EventWatchdog, wd.agents[idx].Name,
+			"node still unresponsive after %d power cycles, giving up",
+			state.attempts)
+		return false
+	}
+	return true
+}
+
+// check - power cycles the node unless it still accepts SSH connections or
+// has been powered off. Every outcome waits for a backoff before the node is
+// checked again
+func (wd *watchdog) check(idx int) {
+	agent := wd.agents[idx]
+	sshAlive := wd.sshAlive(agent)
+
+	wd.mutex.Lock()
+	defer wd.mutex.Unlock()
+
+	state := wd.states[idx]
+	now := time.Now()
+	if sshAlive {
+		if !state.sshAlive {
+			wd.events.Add(EventWatchdog, agent.Name,
+				"agent unresponsive but SSH is reachable, not power cycling")
+		}
+		state.sshAlive = true
+		state.nextAttempt = now.Add(wd.backoff(1))
+		return
+	}
+	state.sshAlive = false
+
+	slot, err := wd.relayCtl.SlotOf(agent.Name)
+	if err != nil {
+		state.gaveUp = true
+		wd.events.Add(EventWatchdog, agent.Name,
+			"node is unresponsive but no relay powers it")
+		return
+	}
+
+	// Node might have been powered off deliberately
+	if on, err := wd.relayCtl.GetState(slot); err != nil || !on {
+		state.nextAttempt = now.Add(wd.backoff(1))
+		return
+	}
+
+	state.attempts++
+	state.nextAttempt = now.Add(wd.backoff(state.attempts))
+
+	wd.events.Add(EventWatchdog, agent.Name,
+		"node unresponsive for %s, power cycling (attempt %d of %d)",
+		now.Sub(state.lastSeen).Round(time.Second),
+		state.attempts,
+		wd.cfg.MaxAttempts)
+
+	delay := time.Duration(wd.cfg.CycleDelaySecs) * time.Second
+	go func() {
+		if err := wd.relayCtl.Cycle(slot, delay); err != nil {
+			wd.events.Add(EventWatchdog, agent.Name,
+				"failed to power cycle node: %v", err)
+		}
+	}()
+}
+
+// backoff - wait after the given number of attempts, doubles with every
+// attempt up to maxWatchdogBackoff
+func (wd *watchdog) backoff(attempts int) time.Duration {
+	backoff := time.Duration(wd.cfg.BackoffSecs) * time.Second
+	for idx := 1; idx < attempts && backoff < maxWatchdogBackoff; idx++ {
+		backoff *= 2
+	}
+	return min(backoff, maxWatchdogBackoff)
+}
+
+func (wd *watchdog) sshAlive(agent *AgentConfig) bool {
+	if agent.SshOpts == nil {
+		return false
+	}
+	port := agent.SshOpts.Port
+	if port == 0 {
+		port = 22
+	}
+	return sshGreets(
+		net.JoinHostPort(agent.SshOpts.Host, fmt.Sprint(port)),
+		sshProbeTimeout)
+}
+
+// sshGreets - checks that the SSH server at the address sends its version
+// banner in time. A hung node can still accept TCP connections in the
+// kernel, so a connection alone does not mean that it is alive. Servers may
+// send other lines before the banner
+func sshGreets(address string, timeout time.Duration) bool {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return false
+	}
+
+	rd := bufio.NewReader(conn)
+	for range 10 {
+		line, err := rd.ReadSlice('\n')
+		if err != nil {
+			return false
+		}
+		if bytes.HasPrefix(line, []byte("SSH-")) {
+			return true
+		}
+	}
+	return false
+}
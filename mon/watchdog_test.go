@@ -0,0 +1,63 @@
+package mon
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeSshServer - accepts connections and sends them the given greeting, a
+// server without greeting keeps the connections open without a word
+func fakeSshServer(t *testing.T, greeting string) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			if greeting != "" {
+				io.WriteString(conn, greeting)
+			}
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestSshGreets(t *testing.T) {
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	tests := []struct {
+		name    string
+		address string
+		want    bool
+	}{
+		{"banner", fakeSshServer(t, "SSH-2.0-OpenSSH_9.2\r\n"), true},
+		{"lines before banner",
+			fakeSshServer(t, "welcome\r\nSSH-2.0-dropbear\r\n"), true},
+		{"other protocol", fakeSshServer(t, "220 localhost ESMTP\r\n"), false},
+		{"silent", fakeSshServer(t, ""), false},
+		{"refused", closedAddr, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := sshGreets(test.address, 200*time.Millisecond)
+			if got != test.want {
+				t.Errorf("expected %v, got %v", test.want, got)
+			}
+		})
+	}
+}
@@ -4,10 +4,13 @@ import (
 	"fmt"
 	"io"
 	"math/rand"
+	"net"
 	"os"
 	"os/user"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	fc "github.com/fatih/color"
 	"github.com/rs/zerolog/log"
@@ -25,24 +28,24 @@ const (
 )
 
 type SshConnOpts struct {
-	Name      string        `json:"name"`
-	Host      string        `json:"host"`
-	Port      int           `json:"port"`
-	UserName  string        `json:"userName"`
-	Password  string        `json:"password"`
-	AuthMehod SshAuthMethod `json:"authMethod"`
-	KeyFile   string        `json:"keyFile"`
-	Color     string        `json:"color"`
+	Name       string        `json:"name"`
+	Host       string        `json:"host"`
+	Port       int           `json:"port"`
+	UserName   string        `json:"userName"`
+	Password   string        `json:"password"`
+	AuthMethod SshAuthMethod `json:"authMethod"`
+	KeyFile    string        `json:"keyFile"`
+	Color      string        `json:"color"`
 }
 
 func (opts *SshConnOpts) String() string {
 	return fmt.Sprintf("[%s] %s@%s:%d",
-		opts.AuthMehod, opts.UserName, opts.Host, opts.Port)
+		opts.AuthMethod, opts.UserName, opts.Host, opts.Port)
 }
 
 func (opts *SshConnOpts) FillDefaults() {
-	if opts.AuthMehod == "" {
-		opts.AuthMehod = SshAuthPublicKey
+	if opts.AuthMethod == "" {
+		opts.AuthMethod = SshAuthPublicKey
 	}
 	if opts.Port == 0 {
 		opts.Port = 22
@@ -63,15 +66,7 @@ type SshConn struct {
 }
 
 func NewConn(opts *SshConnOpts) (*SshConn, error) {
-	var config *ssh.ClientConfig
-	opts.FillDefaults()
-
-	var err error
-	if opts.AuthMehod == SshAuthPublicKey {
-		config, err = getPrivateKeyConfig(opts)
-	} else {
-		config, err = getPasswordConfig(opts)
-	}
+	config, err := clientConfig(opts)
 	if err != nil {
 		return nil, err
 	}
@@ -88,6 +83,44 @@ func NewConn(opts *SshConnOpts) (*SshConn, error) {
 	return &SshConn{opts: opts, client: client}, nil
 }
 
+// DialConn - connects like NewConn, but gives up once the timeout expires and
+// returns errors rather than exiting, so that long running services can use it
+func DialConn(opts *SshConnOpts, timeout time.Duration) (*SshConn, error) {
+	config, err := clientConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	address := net.JoinHostPort(opts.Host, strconv.Itoa(opts.Port))
+	tcpConn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, errx.Errf(err, "failed to connect to '%s'", address)
+	}
+
+	// Deadline covers the handshake and login, a node that accepts the TCP
+	// connection but never answers should not block the caller
+	tcpConn.SetDeadline(time.Now().Add(timeout))
+	sshConn, chans, reqs, err := ssh.NewClientConn(tcpConn, address, config)
+	if err != nil {
+		tcpConn.Close()
+		return nil, errx.Errf(err, "failed to login to '%s'", address)
+	}
+	tcpConn.SetDeadline(time.Time{})
+
+	return &SshConn{
+		opts:   opts,
+		client: ssh.NewClient(sshConn, chans, reqs),
+	}, nil
+}
+
+func clientConfig(opts *SshConnOpts) (*ssh.ClientConfig, error) {
+	opts.FillDefaults()
+	if opts.AuthMethod == SshAuthPublicKey {
+		return getPrivateKeyConfig(opts)
+	}
+	return getPasswordConfig(opts)
+}
+
 func (conn *SshConn) Name() string {
 	return conn.opts.Name
 }
@@ -161,7 +194,7 @@ func (conn *SshConn) createSession() (*ssh.Session, error) {
 	session, err := conn.client.NewSession()
 	if err != nil {
 		const msg = "failed to create SSH session"
-		log.Fatal().Err(err).Str("opts", conn.opts.String()).Msg(msg)
+		log.Error().Err(err).Str("opts", conn.opts.String()).Msg(msg)
 		return nil, errx.Errf(err, msg)
 	}
 	return session, nil
@@ -181,7 +214,7 @@ func getPrivateKeyConfig(opts *SshConnOpts) (*ssh.ClientConfig, error) {
 
 	home, err := os.UserHomeDir()
 	if err != nil {
-		log.Fatal().Err(err)
+		return nil, errx.Errf(err, "failed to get user home directory")
 	}
 
 	key, err := GetPrivateKeyFileContent(opts)
@@ -201,7 +234,7 @@ func getPrivateKeyConfig(opts *SshConnOpts) (*ssh.ClientConfig, error) {
 	hostKeyCallback, err := knownhosts.New(khFile)
 	if err != nil {
 		const msg = "could not create hostkeycallback function"
-		log.Fatal().Err(err).Str("path", khFile).Msg(msg)
+		log.Error().Err(err).Str("path", khFile).Msg(msg)
 		return nil, errx.Errf(err, msg)
 	}
 
@@ -216,12 +249,15 @@ func getPrivateKeyConfig(opts *SshConnOpts) (*ssh.ClientConfig, error) {
 }
 
 func getPasswordConfig(opts *SshConnOpts) (*ssh.ClientConfig, error) {
-	home := iox.MustGetUserHome()
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, errx.Errf(err, "failed to get user home directory")
+	}
 	khFile := filepath.Join(home, ".ssh", "known_hosts")
 	hostKeyCallback, err := knownhosts.New(khFile)
 	if err != nil {
 		const msg = "could not create hostkeycallback function"
-		log.Fatal().Err(err).Str("path", khFile).Msg(msg)
+		log.Error().Err(err).Str("path", khFile).Msg(msg)
 		return nil, errx.Errf(err, msg)
 	}
 
@@ -242,7 +242,7 @@ func (cpr *idMan) verifyConnection() error {
 	// try to connect with public key and check if the copy id worked
 
 	copy := *cpr.conn.opts
-	copy.AuthMehod = SshAuthPublicKey
+	copy.AuthMethod = SshAuthPublicKey
 	conn, err := NewConn(&copy)
 	if err != nil {
 		return errx.Wrap(err)
@@ -47,3 +47,10 @@ func NewNodeWriter(
 // 	password string
 // 	done     bool
 // }
+
+// NewNamedColorNodeWriter - same as NewNodeWriter, but the color is given by
+// its name as in the config, a random color is used for unknown names
+func NewNamedColorNodeWriter(
+	name string, target io.Writer, colorName string) io.Writer {
+	return NewNodeWriter(name, target, color(colorName))
+}